@@ -0,0 +1,229 @@
+package updater
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/blang/semver"
+	"github.com/keys-pub/updater/util"
+)
+
+// Cache is the download cache (temp dir) for an app.
+//
+// Downloaded assets have a record (the asset path with a ".json" extension)
+// with the version and digest, so we can verify them and prune old versions.
+type Cache struct {
+	dir string
+
+	// MaxSize is the maximum total size of cached assets (0 for no limit).
+	MaxSize int64
+	// MaxAge is the maximum age of cached assets (0 for no limit).
+	MaxAge time.Duration
+	// RetainVersions is the number of (newest) versions to keep (0 for no
+	// limit).
+	RetainVersions int
+}
+
+// CachedAsset is an asset in the Cache.
+type CachedAsset struct {
+	Name       string    `json:"name"`
+	Path       string    `json:"path"`
+	Size       int64     `json:"size"`
+	ModTime    time.Time `json:"modTime"`
+	Version    string    `json:"version,omitempty"`
+	URL        string    `json:"url,omitempty"`
+	Digest     string    `json:"digest,omitempty"`
+	DigestType string    `json:"digestType,omitempty"`
+	// Verified is true if the digest matches the file.
+	Verified bool `json:"verified"`
+}
+
+// cacheRecord is saved when an asset is downloaded, with the download info
+// (see util.DownloadInfo).
+type cacheRecord struct {
+	util.DownloadInfo
+	Version string `json:"version"`
+}
+
+// NewCache returns the download cache for an app.
+func NewCache(appName string) *Cache {
+	return &Cache{dir: tempDir(appName)}
+}
+
+// Dir is the cache directory.
+func (c *Cache) Dir() string {
+	return c.dir
+}
+
+func cacheRecordPath(path string) string {
+	return util.DownloadInfoPath(path)
+}
+
+// isCacheAsset returns false for files in the cache that aren't assets, like
+// records, partial downloads, or unzipped directories.
+func isCacheAsset(fileInfo os.FileInfo) bool {
+	if fileInfo.IsDir() {
+		return false
+	}
+	name := fileInfo.Name()
+	return !strings.HasSuffix(name, ".json") && !strings.HasSuffix(name, ".download")
+}
+
+// add saves a record for a downloaded asset.
+func (c *Cache) add(asset *Asset, version string, path string) error {
+	// Keep the download info (validators) saved by the download
+	info, err := util.LoadDownloadInfo(path)
+	if err != nil {
+		logger.Warningf("Invalid download info for %s: %v", path, err)
+	}
+	if info == nil || info.URL != asset.URL {
+		info = &util.DownloadInfo{URL: asset.URL}
+	}
+	info.Digest = asset.Digest
+	info.DigestType = util.DigestType(asset.DigestType)
+	record := cacheRecord{DownloadInfo: *info, Version: version}
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return util.NewFile(cacheRecordPath(path), b, 0600).Save()
+}
+
+// List cached assets, newest first.
+// The digest for each asset is checked against the record from when it was
+// downloaded.
+func (c *Cache) List() ([]*CachedAsset, error) {
+	return c.list(true)
+}
+
+func (c *Cache) list(verify bool) ([]*CachedAsset, error) {
+	files, err := ioutil.ReadDir(c.dir)
+	if os.IsNotExist(err) {
+		return []*CachedAsset{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	assets := []*CachedAsset{}
+	for _, file := range files {
+		if !isCacheAsset(file) {
+			continue
+		}
+		path := filepath.Join(c.dir, file.Name())
+		asset := &CachedAsset{
+			Name:    file.Name(),
+			Path:    path,
+			Size:    file.Size(),
+			ModTime: file.ModTime(),
+		}
+		b, err := ioutil.ReadFile(cacheRecordPath(path))
+		if err == nil {
+			var record cacheRecord
+			if err := json.Unmarshal(b, &record); err != nil {
+				logger.Warningf("Invalid cache record for %s: %v", path, err)
+			} else {
+				asset.Version = record.Version
+				asset.URL = record.URL
+				asset.Digest = record.Digest
+				asset.DigestType = string(record.DigestType)
+			}
+		}
+		if verify && asset.Digest != "" {
+			if typ, err := parseDigestType(asset.DigestType); err == nil {
+				asset.Verified = util.CheckDigest(asset.Digest, path, typ) == nil
+			}
+		}
+		assets = append(assets, asset)
+	}
+
+	sort.SliceStable(assets, func(i, j int) bool {
+		return cachedAssetNewer(assets[i], assets[j])
+	})
+	return assets, nil
+}
+
+// cachedAssetNewer sorts by version (if known) and then modification time.
+func cachedAssetNewer(a *CachedAsset, b *CachedAsset) bool {
+	av, aerr := semver.Make(a.Version)
+	bv, berr := semver.Make(b.Version)
+	switch {
+	case aerr == nil && berr == nil && !av.EQ(bv):
+		return av.GT(bv)
+	case aerr == nil && berr != nil:
+		return true
+	case aerr != nil && berr == nil:
+		return false
+	}
+	return a.ModTime.After(b.ModTime)
+}
+
+// Prune removes assets from the cache by age, number of versions and total
+// size, keeping the newest assets and any paths in except.
+// Returns the removed paths.
+func (c *Cache) Prune(except ...string) ([]string, error) {
+	assets, err := c.list(false)
+	if err != nil {
+		return nil, err
+	}
+	keep := map[string]bool{}
+	for _, path := range except {
+		keep[filepath.Base(path)] = true
+	}
+
+	// Assets we keep count towards the limits
+	versions := map[string]bool{}
+	size := int64(0)
+	for _, asset := range assets {
+		if keep[asset.Name] {
+			size += asset.Size
+			versions[asset.Version] = true
+		}
+	}
+
+	now := time.Now()
+	removed := []string{}
+	for _, asset := range assets {
+		if keep[asset.Name] {
+			continue
+		}
+		remove := false
+		switch {
+		case c.MaxAge > 0 && now.Sub(asset.ModTime) > c.MaxAge:
+			remove = true
+		case c.RetainVersions > 0 && !versions[asset.Version] && len(versions) >= c.RetainVersions:
+			remove = true
+		case c.MaxSize > 0 && size+asset.Size > c.MaxSize:
+			remove = true
+		}
+		if !remove {
+			size += asset.Size
+			versions[asset.Version] = true
+			continue
+		}
+		logger.Infof("Cache, removing %s", asset.Path)
+		if err := c.remove(asset.Path); err != nil {
+			return removed, err
+		}
+		removed = append(removed, asset.Path)
+	}
+	return removed, nil
+}
+
+func (c *Cache) remove(path string) error {
+	if err := os.RemoveAll(path); err != nil {
+		return err
+	}
+	return os.RemoveAll(cacheRecordPath(path))
+}
+
+// Clear removes everything in the cache.
+func (c *Cache) Clear() error {
+	logger.Infof("Cache, removing %s", c.dir)
+	return os.RemoveAll(c.dir)
+}
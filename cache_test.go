@@ -0,0 +1,138 @@
+package updater
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/keys-pub/updater/util"
+	"github.com/stretchr/testify/require"
+)
+
+func testCache(t *testing.T, appName string) *Cache {
+	cache := NewCache(appName)
+	util.RemoveFileAtPath(cache.Dir())
+	err := util.MakeDirs(cache.Dir(), 0700)
+	require.NoError(t, err)
+	return cache
+}
+
+func testCacheAdd(t *testing.T, cache *Cache, name string, version string, data []byte, age time.Duration) string {
+	path := filepath.Join(cache.Dir(), name)
+	err := ioutil.WriteFile(path, data, 0600)
+	require.NoError(t, err)
+	digest, err := util.DigestForFileAtPath(path, util.SHA256)
+	require.NoError(t, err)
+	err = cache.add(&Asset{Name: name, URL: "https://example.com/" + name, Digest: digest, DigestType: "sha256"}, version, path)
+	require.NoError(t, err)
+	modTime := time.Now().Add(-age)
+	err = os.Chtimes(path, modTime, modTime)
+	require.NoError(t, err)
+	return path
+}
+
+func cachedNames(t *testing.T, cache *Cache) []string {
+	assets, err := cache.List()
+	require.NoError(t, err)
+	names := []string{}
+	for _, asset := range assets {
+		names = append(names, asset.Name)
+	}
+	return names
+}
+
+func TestCacheList(t *testing.T) {
+	cache := testCache(t, "TestCacheList")
+	defer util.RemoveFileAtPath(cache.Dir())
+
+	testCacheAdd(t, cache, "Keys-0.0.9.zip", "0.0.9", []byte("v9"), 0)
+	path := testCacheAdd(t, cache, "Keys-0.0.10.zip", "0.0.10", []byte("v10"), time.Hour)
+	err := ioutil.WriteFile(filepath.Join(cache.Dir(), "Keys-0.0.11.zip.download"), []byte("partial"), 0600)
+	require.NoError(t, err)
+
+	assets, err := cache.List()
+	require.NoError(t, err)
+	require.Equal(t, 2, len(assets))
+	require.Equal(t, "Keys-0.0.10.zip", assets[0].Name)
+	require.Equal(t, "0.0.10", assets[0].Version)
+	require.True(t, assets[0].Verified)
+	require.Equal(t, "Keys-0.0.9.zip", assets[1].Name)
+	require.True(t, assets[1].Verified)
+
+	// Corrupt
+	err = ioutil.WriteFile(path, []byte("corrupted"), 0600)
+	require.NoError(t, err)
+	assets, err = cache.List()
+	require.NoError(t, err)
+	require.False(t, assets[0].Verified)
+}
+
+func TestCachePrune(t *testing.T) {
+	cache := testCache(t, "TestCachePrune")
+	defer util.RemoveFileAtPath(cache.Dir())
+
+	testCacheAdd(t, cache, "Keys-0.0.1.zip", "0.0.1", []byte("1"), 48*time.Hour)
+	testCacheAdd(t, cache, "Keys-0.0.2.zip", "0.0.2", []byte("22"), 2*time.Hour)
+	testCacheAdd(t, cache, "Keys-0.0.3.zip", "0.0.3", []byte("333"), time.Hour)
+	testCacheAdd(t, cache, "Keys-0.0.4.zip", "0.0.4", []byte("4444"), 0)
+
+	cache.MaxAge = 24 * time.Hour
+	removed, err := cache.Prune()
+	require.NoError(t, err)
+	require.Equal(t, []string{filepath.Join(cache.Dir(), "Keys-0.0.1.zip")}, removed)
+	exists, err := util.FileExists(filepath.Join(cache.Dir(), "Keys-0.0.1.zip.json"))
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	cache.MaxAge = 0
+	cache.RetainVersions = 2
+	_, err = cache.Prune(filepath.Join(cache.Dir(), "Keys-0.0.2.zip"))
+	require.NoError(t, err)
+	require.Equal(t, []string{"Keys-0.0.4.zip", "Keys-0.0.2.zip"}, cachedNames(t, cache))
+
+	cache.RetainVersions = 0
+	cache.MaxSize = 5
+	_, err = cache.Prune()
+	require.NoError(t, err)
+	require.Equal(t, []string{"Keys-0.0.4.zip"}, cachedNames(t, cache))
+
+	err = cache.Clear()
+	require.NoError(t, err)
+	require.Equal(t, []string{}, cachedNames(t, cache))
+}
+
+func TestCleanup(t *testing.T) {
+	cache := testCache(t, "TestCleanup")
+	defer util.RemoveFileAtPath(cache.Dir())
+
+	testCacheAdd(t, cache, "Keys-0.0.1.zip", "0.0.1", []byte("1"), 0)
+	path := testCacheAdd(t, cache, "Keys-0.0.2.zip", "0.0.2", []byte("2"), 0)
+
+	Cleanup("TestCleanup", path)
+	files, err := ioutil.ReadDir(cache.Dir())
+	require.NoError(t, err)
+	names := []string{}
+	for _, f := range files {
+		names = append(names, f.Name())
+	}
+	require.Equal(t, []string{"Keys-0.0.2.zip", "Keys-0.0.2.zip.json"}, names)
+}
+
+func TestCacheAddKeepsDownloadInfo(t *testing.T) {
+	cache := testCache(t, "TestCacheAddKeepsDownloadInfo")
+	defer util.RemoveFileAtPath(cache.Dir())
+
+	path := filepath.Join(cache.Dir(), "Keys-0.0.9.zip")
+	err := ioutil.WriteFile(path, []byte("v9"), 0600)
+	require.NoError(t, err)
+	err = util.DownloadInfo{URL: "https://example.com/Keys-0.0.9.zip", ETag: `"v9"`}.Save(path)
+	require.NoError(t, err)
+
+	err = cache.add(&Asset{Name: "Keys-0.0.9.zip", URL: "https://example.com/Keys-0.0.9.zip", Digest: "abc", DigestType: "sha256"}, "0.0.9", path)
+	require.NoError(t, err)
+	info, err := util.LoadDownloadInfo(path)
+	require.NoError(t, err)
+	require.Equal(t, &util.DownloadInfo{URL: "https://example.com/Keys-0.0.9.zip", ETag: `"v9"`, Digest: "abc", DigestType: util.SHA256}, info)
+}
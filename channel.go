@@ -0,0 +1,81 @@
+package updater
+
+import (
+	"strings"
+
+	"github.com/blang/semver"
+	"github.com/pkg/errors"
+)
+
+// Channel is a release channel.
+//
+// Channels include the releases of the channels before them, so the beta
+// channel includes stable releases, alpha includes beta and stable, and
+// nightly includes everything.
+type Channel string
+
+const (
+	// Stable releases (versions without a prerelease, for example, 1.2.3).
+	Stable Channel = "stable"
+	// Beta releases (for example, 1.2.3-beta.1 or 1.2.3-rc.1).
+	// Prereleases without a channel name (for example, 1.2.3-400) are beta.
+	Beta Channel = "beta"
+	// Alpha releases (for example, 1.2.3-alpha.1).
+	Alpha Channel = "alpha"
+	// Nightly releases (for example, 1.2.3-nightly.20200101 or 1.2.3-dev.1).
+	Nightly Channel = "nightly"
+)
+
+var channels = []Channel{Stable, Beta, Alpha, Nightly}
+
+// ParseChannel parses a channel name.
+func ParseChannel(s string) (Channel, error) {
+	for _, c := range channels {
+		if string(c) == strings.ToLower(s) {
+			return c, nil
+		}
+	}
+	return "", errors.Errorf("Unknown channel %q", s)
+}
+
+func (c Channel) rank() int {
+	for i, ch := range channels {
+		if ch == c {
+			return i
+		}
+	}
+	return 0
+}
+
+// VersionChannel returns the channel for a version, from its (first)
+// prerelease identifier.
+func VersionChannel(v semver.Version) Channel {
+	if len(v.Pre) == 0 {
+		return Stable
+	}
+	switch strings.ToLower(v.Pre[0].VersionStr) {
+	case "alpha":
+		return Alpha
+	case "nightly", "dev":
+		return Nightly
+	default:
+		return Beta
+	}
+}
+
+// Includes returns true if version is released in the channel.
+func (c Channel) Includes(v semver.Version) bool {
+	return VersionChannel(v).rank() <= c.rank()
+}
+
+// ReleaseChannel returns the channel for options.
+// If Channel isn't set, Prerelease is the beta channel, otherwise stable.
+func (o UpdateOptions) ReleaseChannel() Channel {
+	if o.Channel != "" {
+		return o.Channel
+	}
+	if o.Prerelease {
+		return Beta
+	}
+	return Stable
+}
@@ -0,0 +1,35 @@
+package updater
+
+import (
+	"testing"
+
+	"github.com/blang/semver"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChannel(t *testing.T) {
+	c, err := ParseChannel("Beta")
+	require.NoError(t, err)
+	require.Equal(t, Beta, c)
+	_, err = ParseChannel("edge")
+	require.EqualError(t, err, `Unknown channel "edge"`)
+
+	require.Equal(t, Stable, VersionChannel(semver.MustParse("1.2.3")))
+	require.Equal(t, Stable, VersionChannel(semver.MustParse("1.2.3+cafebeef")))
+	require.Equal(t, Beta, VersionChannel(semver.MustParse("1.2.3-beta.1")))
+	require.Equal(t, Beta, VersionChannel(semver.MustParse("1.2.3-rc.1")))
+	require.Equal(t, Beta, VersionChannel(semver.MustParse("1.2.3-400")))
+	require.Equal(t, Alpha, VersionChannel(semver.MustParse("1.2.3-alpha.1")))
+	require.Equal(t, Nightly, VersionChannel(semver.MustParse("1.2.3-nightly.20200101")))
+	require.Equal(t, Nightly, VersionChannel(semver.MustParse("1.2.3-dev.1")))
+
+	require.True(t, Stable.Includes(semver.MustParse("1.2.3")))
+	require.False(t, Stable.Includes(semver.MustParse("1.2.3-beta.1")))
+	require.True(t, Beta.Includes(semver.MustParse("1.2.3-beta.1")))
+	require.False(t, Beta.Includes(semver.MustParse("1.2.3-alpha.1")))
+	require.True(t, Nightly.Includes(semver.MustParse("1.2.3-alpha.1")))
+
+	require.Equal(t, Stable, UpdateOptions{}.ReleaseChannel())
+	require.Equal(t, Beta, UpdateOptions{Prerelease: true}.ReleaseChannel())
+	require.Equal(t, Alpha, UpdateOptions{Prerelease: true, Channel: Alpha}.ReleaseChannel())
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/keys-pub/updater"
+	"github.com/pkg/errors"
+)
+
+type cacheFlags struct {
+	appName        string
+	maxSize        int64
+	maxAge         time.Duration
+	retainVersions int
+}
+
+// runCache runs the cache command, for example:
+//
+//   updater cache list -app-name Keys
+//   updater cache prune -app-name Keys -retain 2 -max-age 720h
+//   updater cache clear -app-name Keys
+//
+func runCache(args []string) error {
+	if len(args) == 0 {
+		return errors.Errorf("No cache command specified (list, prune, clear)")
+	}
+	command := args[0]
+
+	f := cacheFlags{}
+	fs := flag.NewFlagSet("cache "+command, flag.ContinueOnError)
+	fs.StringVar(&f.appName, "app-name", "", "App name")
+	fs.Int64Var(&f.maxSize, "max-size", 0, "Maximum size in bytes (prune)")
+	fs.DurationVar(&f.maxAge, "max-age", 0, "Maximum age (prune)")
+	fs.IntVar(&f.retainVersions, "retain", 0, "Number of versions to keep (prune)")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if f.appName == "" {
+		return errors.Errorf("No app name specified (-app-name)")
+	}
+
+	cache := updater.NewCache(f.appName)
+	cache.MaxSize = f.maxSize
+	cache.MaxAge = f.maxAge
+	cache.RetainVersions = f.retainVersions
+
+	switch command {
+	case "list":
+		assets, err := cache.List()
+		if err != nil {
+			return err
+		}
+		return printJSON(assets)
+	case "prune":
+		removed, err := cache.Prune()
+		if err != nil {
+			return err
+		}
+		return printJSON(removed)
+	case "clear":
+		return cache.Clear()
+	default:
+		return errors.Errorf("Unknown cache command: %s", command)
+	}
+}
+
+func printJSON(i interface{}) error {
+	b, err := json.MarshalIndent(i, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(b))
+	return nil
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/keys-pub/updater"
+	"github.com/keys-pub/updater/util"
+	"github.com/pkg/errors"
+)
+
+// minRetry is the delay before retrying after a failure (doubled for each
+// consecutive failure, up to the interval).
+const minRetry = time.Minute
+
+// daemon checks for (and applies) updates.
+// The lock guards the state and the pending update, and isn't held while
+// checking, downloading or applying (see begin).
+type daemon struct {
+	sync.Mutex
+	r        *runner
+	interval time.Duration
+	update   *updater.Update
+	busy     bool
+	checkNow chan struct{}
+	// failures is the number of consecutive checks (including the download)
+	// that failed, for backoff (see nextCheck).
+	failures int
+}
+
+// daemonResponse is the response for a control socket command.
+type daemonResponse struct {
+	Update *updater.Update `json:"update,omitempty"`
+	State  *updater.State  `json:"state,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// runDaemon checks for updates on a schedule, for example:
+//
+//   updater daemon -github keys-pub/app -app-name Keys -current 0.0.17 -download -interval 1h
+//
+// The interval defaults to UPDATER_INTERVAL (or 1h).
+// The daemon listens on a unix socket for commands (check-now, status, apply),
+// one per line, and responds with a line of JSON.
+func runDaemon(ctx context.Context, args []string) error {
+	f := flags{}
+	fs := flag.NewFlagSet("daemon", flag.ContinueOnError)
+	registerFlags(fs, &f)
+	var interval time.Duration
+	var socketPath string
+	fs.DurationVar(&interval, "interval", util.EnvDuration("UPDATER_INTERVAL", time.Hour), "Interval to check for updates")
+	fs.StringVar(&socketPath, "socket", "", "Control socket path (defaults to updater.sock in the app config directory)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if interval <= 0 {
+		return errors.Errorf("Invalid interval %s", interval)
+	}
+
+	setLoggers(NewLogger(logLevel(f)))
+
+	r, err := newRunner(f)
+	if err != nil {
+		return err
+	}
+	if socketPath == "" {
+		dataDir, err := updater.DataDir(f.appName)
+		if err != nil {
+			return err
+		}
+		socketPath = filepath.Join(dataDir, "updater.sock")
+	}
+
+	d := newDaemon(r, interval)
+	ln, err := listenUnix(socketPath)
+	if err != nil {
+		return err
+	}
+	go d.serve(ctx, ln)
+	defer func() {
+		if err := ln.Close(); err != nil {
+			logger.Warningf("Error closing control socket: %v", err)
+		}
+	}()
+
+	d.run(ctx)
+	return nil
+}
+
+func newDaemon(r *runner, interval time.Duration) *daemon {
+	return &daemon{
+		r:        r,
+		interval: interval,
+		checkNow: make(chan struct{}, 1),
+	}
+}
+
+// listenUnix listens on a unix socket (removing a stale socket).
+// The socket is only accessible by us (0600), and its directory must be owned
+// by us and not accessible by other users.
+func listenUnix(socketPath string) (net.Listener, error) {
+	if err := util.MakeParentDirs(socketPath, 0700); err != nil {
+		return nil, err
+	}
+	if err := checkSocketDir(filepath.Dir(socketPath)); err != nil {
+		return nil, err
+	}
+	if err := os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	logger.Infof("Listening on %s", socketPath)
+	return listenSocket(socketPath)
+}
+
+// nextCheck returns the delay until the next check, with up to 10% jitter.
+// After failures, we retry sooner (backing off up to the interval).
+func nextCheck(interval time.Duration, failures int, jitter float64) time.Duration {
+	delay := interval
+	if failures > 0 {
+		delay = minRetry
+		for i := 1; i < failures && delay < interval; i++ {
+			delay *= 2
+		}
+		if delay > interval {
+			delay = interval
+		}
+	}
+	return delay + time.Duration(float64(delay)*0.1*(jitter*2-1))
+}
+
+// run checks for updates until the context is done.
+func (d *daemon) run(ctx context.Context) {
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	check := true
+	for {
+		if check {
+			if _, err := d.check(ctx); err != nil {
+				logger.Errorf("Error checking for update: %v", err)
+			}
+		}
+		delay := d.nextCheck(rnd.Float64())
+
+		logger.Infof("Next check in %s", delay)
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.checkNow:
+			// Checked (from the control socket), so reset the schedule
+			check = false
+		case <-time.After(delay):
+			check = true
+		}
+	}
+}
+
+// nextCheck returns the delay until the next check (see nextCheck).
+func (d *daemon) nextCheck(jitter float64) time.Duration {
+	d.Lock()
+	defer d.Unlock()
+	return nextCheck(d.interval, d.failures, jitter)
+}
+
+// begin marks the daemon busy, so we only check (or apply) one at a time,
+// without holding the lock.
+// Only the busy caller uses the runner (except for the state), until end.
+func (d *daemon) begin() error {
+	d.Lock()
+	defer d.Unlock()
+	if d.busy {
+		return errors.Errorf("Check or apply in progress")
+	}
+	d.busy = true
+	return nil
+}
+
+// end saves the state and clears busy.
+func (d *daemon) end() {
+	d.Lock()
+	defer d.Unlock()
+	d.r.saveState()
+	d.busy = false
+}
+
+// locked calls fn with the lock held.
+func (d *daemon) locked(fn func()) {
+	d.Lock()
+	defer d.Unlock()
+	fn()
+}
+
+// check for an update, and download it (if -download), and save the state.
+// If the check or download fails, we count a failure (a successful check
+// doesn't reset failures if the download fails).
+func (d *daemon) check(ctx context.Context) (update *updater.Update, err error) {
+	if err := d.begin(); err != nil {
+		return nil, err
+	}
+	defer d.end()
+	defer d.locked(func() {
+		if err != nil {
+			d.failures++
+		} else {
+			d.failures = 0
+		}
+	})
+
+	update, err = d.r.upd.CheckForUpdate(ctx, d.r.options)
+	d.locked(func() { d.r.state.RecordCheck(update, err) })
+	if err != nil {
+		return nil, err
+	}
+	if update != nil && update.NeedUpdate && d.r.f.download {
+		err = d.r.upd.Download(ctx, update, d.r.options)
+		d.locked(func() { d.r.state.RecordDownload(update, err) })
+		if err != nil {
+			return update, err
+		}
+		if update.Asset != nil {
+			updater.Cleanup(d.r.options.AppName, update.Asset.LocalPath)
+		}
+	}
+	d.locked(func() { d.update = update })
+	return update, nil
+}
+
+// apply the last downloaded update, and save the state.
+func (d *daemon) apply() (*updater.Update, error) {
+	if err := d.begin(); err != nil {
+		return nil, err
+	}
+	defer d.end()
+
+	var update *updater.Update
+	d.locked(func() { update = d.update })
+	if update == nil || !update.NeedUpdate {
+		return nil, errors.Errorf("No update to apply")
+	}
+	if d.r.f.apply == "" {
+		return nil, errors.Errorf("No apply path specified (-apply)")
+	}
+	if err := checkLocalAsset(update); err != nil {
+		return nil, err
+	}
+	err := d.r.install(update)
+	d.locked(func() {
+		d.r.recordApply(update, err)
+		if err == nil {
+			// We are now at the update version
+			d.r.options.Version = update.Version
+			update.NeedUpdate = false
+		}
+	})
+	return update, err
+}
+
+func (d *daemon) serve(ctx context.Context, ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			logger.Infof("Control socket closed: %v", err)
+			return
+		}
+		go d.handle(ctx, conn)
+	}
+}
+
+func (d *daemon) handle(ctx context.Context, conn net.Conn) {
+	defer util.Close(conn)
+	scanner := bufio.NewScanner(conn)
+	enc := json.NewEncoder(conn)
+	for scanner.Scan() {
+		command := strings.TrimSpace(scanner.Text())
+		if command == "" {
+			continue
+		}
+		resp := d.command(ctx, command)
+		if err := enc.Encode(resp); err != nil {
+			logger.Warningf("Error writing to control socket: %v", err)
+			return
+		}
+	}
+}
+
+func (d *daemon) command(ctx context.Context, command string) daemonResponse {
+	logger.Infof("Control command: %s", command)
+	var update *updater.Update
+	var err error
+	switch command {
+	case "check-now":
+		update, err = d.check(ctx)
+		select {
+		case d.checkNow <- struct{}{}:
+		default:
+		}
+	case "status":
+		d.Lock()
+		defer d.Unlock()
+		state := *d.r.state
+		return daemonResponse{Update: copyUpdate(d.update), State: &state}
+	case "apply":
+		update, err = d.apply()
+	default:
+		err = errors.Errorf("Unknown command: %s", command)
+	}
+	resp := daemonResponse{}
+	d.locked(func() { resp.Update = copyUpdate(update) })
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	return resp
+}
+
+// copyUpdate returns a copy of the update (for a response), since the
+// pending update can change after we release the lock.
+func copyUpdate(update *updater.Update) *updater.Update {
+	if update == nil {
+		return nil
+	}
+	u := *update
+	return &u
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/keys-pub/updater"
+	"github.com/keys-pub/updater/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNextCheck(t *testing.T) {
+	require.Equal(t, time.Hour, nextCheck(time.Hour, 0, 0.5))
+	require.Equal(t, 54*time.Minute, nextCheck(time.Hour, 0, 0))
+	require.Equal(t, 66*time.Minute, nextCheck(time.Hour, 0, 1))
+	require.Equal(t, time.Minute, nextCheck(time.Hour, 1, 0.5))
+	require.Equal(t, 4*time.Minute, nextCheck(time.Hour, 3, 0.5))
+	require.Equal(t, time.Hour, nextCheck(time.Hour, 10, 0.5))
+	require.Equal(t, 30*time.Second, nextCheck(30*time.Second, 1, 0.5))
+}
+
+// testDaemonClient connects to the control socket and returns a func to send
+// a command, and the connection (to close).
+func testDaemonClient(t *testing.T, socketPath string) (func(command string) daemonResponse, net.Conn) {
+	conn, err := net.Dial("unix", socketPath)
+	require.NoError(t, err)
+	reader := bufio.NewReader(conn)
+	return func(command string) daemonResponse {
+		_, err := conn.Write([]byte(command + "\n"))
+		require.NoError(t, err)
+		line, err := reader.ReadBytes('\n')
+		require.NoError(t, err)
+		var resp daemonResponse
+		err = json.Unmarshal(line, &resp)
+		require.NoError(t, err)
+		return resp
+	}, conn
+}
+
+func TestDaemon(t *testing.T) {
+	dir, err := util.MakeTempDir("TestDaemon.", 0700)
+	require.NoError(t, err)
+	defer util.RemoveFileAtPath(dir)
+	err = util.CopyFile("../../test/test.zip", filepath.Join(dir, "test.zip"))
+	require.NoError(t, err)
+	update := `{"version": "1.0.1", "asset": {"name": "test.zip", "url": "test.zip", "digest": "54970995e4d02da631e0634162ef66e2663e0eee7d018e816ac48ed6f7811c84"}}`
+	err = ioutil.WriteFile(filepath.Join(dir, "update-linux.json"), []byte(update), 0600)
+	require.NoError(t, err)
+
+	r, err := newRunner(flags{appName: "TestDaemon", current: "1.0.0", local: dir, platform: "linux", download: true})
+	require.NoError(t, err)
+	r.state, err = updater.LoadState(dir)
+	require.NoError(t, err)
+	defer updater.NewCache("TestDaemon").Clear()
+
+	socketPath := filepath.Join(dir, "updater.sock")
+	ln, err := listenUnix(socketPath)
+	require.NoError(t, err)
+	defer ln.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d := newDaemon(r, time.Hour)
+	go d.serve(ctx, ln)
+
+	command, conn := testDaemonClient(t, socketPath)
+	defer conn.Close()
+
+	resp := command("status")
+	require.Nil(t, resp.Update)
+	require.NotNil(t, resp.State)
+	require.True(t, resp.State.LastCheck.IsZero())
+
+	resp = command("check-now")
+	require.Equal(t, "", resp.Error)
+	require.Equal(t, "1.0.1", resp.Update.Version)
+	require.NotEmpty(t, resp.Update.Asset.LocalPath)
+
+	resp = command("status")
+	require.Equal(t, "1.0.1", resp.State.LastVersion)
+	require.Equal(t, resp.Update.Asset.LocalPath, resp.State.DownloadPath)
+
+	// State was saved
+	state, err := updater.LoadState(dir)
+	require.NoError(t, err)
+	require.Equal(t, "1.0.1", state.LastVersion)
+
+	resp = command("apply")
+	require.Equal(t, "No apply path specified (-apply)", resp.Error)
+
+	resp = command("invalid")
+	require.Equal(t, "Unknown command: invalid", resp.Error)
+}
+
+// blockingSource blocks FindUpdate until unblocked.
+type blockingSource struct {
+	started chan struct{}
+	unblock chan struct{}
+}
+
+func (s blockingSource) Description() string {
+	return "Blocking"
+}
+
+func (s blockingSource) FindUpdate(ctx context.Context, options updater.UpdateOptions) (*updater.Update, error) {
+	close(s.started)
+	<-s.unblock
+	return &updater.Update{Version: "1.0.1", NeedUpdate: true}, nil
+}
+
+func TestDaemonCheckUnlocked(t *testing.T) {
+	dir, err := util.MakeTempDir("TestDaemonCheckUnlocked.", 0700)
+	require.NoError(t, err)
+	defer util.RemoveFileAtPath(dir)
+
+	r, err := newRunner(flags{appName: "TestDaemonCheckUnlocked", current: "1.0.0", local: dir, platform: "linux"})
+	require.NoError(t, err)
+	r.state, err = updater.LoadState(dir)
+	require.NoError(t, err)
+	src := blockingSource{started: make(chan struct{}), unblock: make(chan struct{})}
+	r.upd = updater.NewUpdater(src)
+
+	socketPath := filepath.Join(dir, "updater.sock")
+	ln, err := listenUnix(socketPath)
+	require.NoError(t, err)
+	defer ln.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d := newDaemon(r, time.Hour)
+	go d.serve(ctx, ln)
+
+	checked := make(chan daemonResponse)
+	checkCommand, checkConn := testDaemonClient(t, socketPath)
+	defer checkConn.Close()
+	go func() { checked <- checkCommand("check-now") }()
+	<-src.started
+
+	// Status (and other commands) don't wait for the check
+	command, conn := testDaemonClient(t, socketPath)
+	defer conn.Close()
+	resp := command("status")
+	require.Nil(t, resp.Update)
+	resp = command("check-now")
+	require.Equal(t, "Check or apply in progress", resp.Error)
+	resp = command("apply")
+	require.Equal(t, "Check or apply in progress", resp.Error)
+
+	close(src.unblock)
+	resp = <-checked
+	require.Equal(t, "", resp.Error)
+	require.Equal(t, "1.0.1", resp.Update.Version)
+	resp = command("status")
+	require.Equal(t, "1.0.1", resp.Update.Version)
+	require.Equal(t, "1.0.1", resp.State.LastVersion)
+}
+
+func TestDaemonNoAsset(t *testing.T) {
+	dir, err := util.MakeTempDir("TestDaemonNoAsset.", 0700)
+	require.NoError(t, err)
+	defer util.RemoveFileAtPath(dir)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"version": "1.0.1"}`))
+	}))
+	defer server.Close()
+
+	r, err := newRunner(flags{appName: "TestDaemonNoAsset", current: "1.0.0", url: server.URL + "/update.json", platform: "linux", download: true})
+	require.NoError(t, err)
+	r.state, err = updater.LoadState(dir)
+	require.NoError(t, err)
+
+	d := newDaemon(r, time.Hour)
+	update, err := d.check(context.TODO())
+	require.NoError(t, err)
+	require.NotNil(t, update)
+	require.Equal(t, "1.0.1", update.Version)
+	require.True(t, update.NeedUpdate)
+	require.Nil(t, update.Asset)
+}
+
+func TestDaemonDownloadBackoff(t *testing.T) {
+	dir, err := util.MakeTempDir("TestDaemonDownloadBackoff.", 0700)
+	require.NoError(t, err)
+	defer util.RemoveFileAtPath(dir)
+	defer updater.NewCache("TestDaemonDownloadBackoff").Clear()
+	err = util.CopyFile("../../test/test.zip", filepath.Join(dir, "test.zip"))
+	require.NoError(t, err)
+	// Invalid digest, so the download always fails
+	update := `{"version": "1.0.1", "asset": {"name": "test.zip", "url": "test.zip", "digest": "deadbeef"}}`
+	err = ioutil.WriteFile(filepath.Join(dir, "update-linux.json"), []byte(update), 0600)
+	require.NoError(t, err)
+
+	r, err := newRunner(flags{appName: "TestDaemonDownloadBackoff", current: "1.0.0", local: dir, platform: "linux", download: true})
+	require.NoError(t, err)
+	r.state, err = updater.LoadState(dir)
+	require.NoError(t, err)
+
+	d := newDaemon(r, time.Hour)
+	require.Equal(t, time.Hour, d.nextCheck(0.5))
+	expected := []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute}
+	for _, delay := range expected {
+		_, err := d.check(context.TODO())
+		require.Error(t, err)
+		require.Equal(t, delay, d.nextCheck(0.5))
+	}
+}
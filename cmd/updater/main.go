@@ -4,14 +4,23 @@
 package main
 
 import (
+	"context"
+	"crypto/ed25519"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
 	"runtime"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/keys-pub/updater"
 	"github.com/keys-pub/updater/github"
+	"github.com/keys-pub/updater/httpjson"
+	"github.com/keys-pub/updater/local"
 	"github.com/keys-pub/updater/util"
 	"github.com/pkg/errors"
 )
@@ -21,75 +30,145 @@ type flags struct {
 	logToFile  bool
 	appName    string
 	github     string
+	url        string
+	local      string
 	platform   string
+	arch       string
+	format     string
 	current    string
 	download   bool
 	apply      string
 	prerelease bool
+	channel    string
+	publicKeys string
+	progress   string
+
+	downgrade    bool
+	compareBuild bool
+	minVersion   string
+	maxVersion   string
+	skipVersions string
+
+	healthCheck        string
+	healthCheckExec    string
+	healthCheckTimeout time.Duration
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "cache" {
+		setLoggers(NewLogger(ErrLevel))
+		if err := runCache(os.Args[2:]); err != nil {
+			logFatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "status" {
+		setLoggers(NewLogger(ErrLevel))
+		if err := runStatus(os.Args[2:]); err != nil {
+			logFatal(err)
+		}
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go cancelOnSignal(cancel)
+
+	if len(os.Args) > 1 && os.Args[1] == "daemon" {
+		if err := runDaemon(ctx, os.Args[2:]); err != nil {
+			logFatal(err)
+		}
+		return
+	}
+
 	f := loadFlags()
-	if err := run(f); err != nil {
+	if err := run(ctx, f); err != nil {
 		logFatal(err)
 	}
 }
 
 func loadFlags() flags {
 	f := flags{}
-	flag.BoolVar(&f.version, "version", false, "Show version")
-	flag.BoolVar(&f.logToFile, "log-to-file", false, "Log to file")
-	flag.StringVar(&f.appName, "app-name", "", "App name")
-	flag.StringVar(&f.github, "github", "", "Github repo")
-	flag.StringVar(&f.platform, "platform", runtime.GOOS, "Platform")
-	flag.StringVar(&f.current, "current", "", "Current version")
-	flag.BoolVar(&f.download, "download", false, "Download update")
-	flag.BoolVar(&f.prerelease, "prerelease", false, "Prerelease")
-	flag.StringVar(&f.apply, "apply", "", "Apply")
+	registerFlags(flag.CommandLine, &f)
 	flag.Parse()
 	return f
 }
 
+func registerFlags(fs *flag.FlagSet, f *flags) {
+	fs.BoolVar(&f.version, "version", false, "Show version")
+	fs.BoolVar(&f.logToFile, "log-to-file", false, "Log to file")
+	fs.StringVar(&f.appName, "app-name", "", "App name")
+	fs.StringVar(&f.github, "github", "", "Github repo")
+	fs.StringVar(&f.local, "local", "", "Local directory with releases")
+	fs.StringVar(&f.url, "url", "", "Update JSON URL (template with {appName}, {platform}, {arch}, {channel})")
+	fs.StringVar(&f.platform, "platform", runtime.GOOS, "Platform")
+	fs.StringVar(&f.arch, "arch", runtime.GOARCH, "Architecture (for example, amd64 or arm64)")
+	fs.StringVar(&f.format, "format", "", "Asset format (for example, zip, dmg, AppImage or deb), defaults to the format we can apply")
+	fs.StringVar(&f.current, "current", "", "Current version")
+	fs.BoolVar(&f.download, "download", false, "Download update")
+	fs.BoolVar(&f.prerelease, "prerelease", false, "Prerelease (same as -channel beta)")
+	fs.StringVar(&f.channel, "channel", "", "Release channel (stable, beta, alpha, nightly)")
+	fs.BoolVar(&f.downgrade, "allow-downgrade", false, "Allow downgrade to the latest release in the channel, if the current version isn't in it")
+	fs.BoolVar(&f.compareBuild, "compare-build", false, "Compare build metadata, so a version with different build metadata is an update")
+	fs.StringVar(&f.minVersion, "min-version", "", "Minimum version to update to")
+	fs.StringVar(&f.maxVersion, "max-version", "", "Maximum version to update to")
+	fs.StringVar(&f.skipVersions, "skip-versions", "", "Versions not to update to (comma separated)")
+	fs.StringVar(&f.apply, "apply", "", "Apply")
+	fs.StringVar(&f.progress, "progress", "", "Show download progress (json)")
+	fs.StringVar(&f.publicKeys, "public-keys", "", "Public keys (base64, comma separated) to verify manifest (Github) or asset signature")
+	fs.StringVar(&f.healthCheck, "health-check", "", "Args to run the installed app with after apply, to check it outputs the update version (for example, --version), not supported on Windows")
+	fs.StringVar(&f.healthCheckExec, "health-check-exec", "", "Executable to run for health check (relative to the apply path, for example, Contents/MacOS/Keys)")
+	fs.DurationVar(&f.healthCheckTimeout, "health-check-timeout", 10*time.Second, "Timeout for health check")
+}
+
 func logFatal(err error) {
 	fmt.Fprintf(os.Stderr, "%v\n", err)
 	os.Exit(1)
 }
 
-func run(f flags) error {
-	if f.version {
-		fmt.Printf("%s\n", updater.Version)
-		return nil
-	}
+// cancelOnSignal cancels (for example, an in progress download) if we are
+// interrupted or terminated.
+func cancelOnSignal(cancel context.CancelFunc) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	<-c
+	cancel()
+}
 
-	log := NewLogger(InfoLevel)
+func setLoggers(log Logger) {
 	SetLogger(log)
 	updater.SetLogger(log)
 	util.SetLogger(log)
 	github.SetLogger(log)
+	httpjson.SetLogger(log)
+	local.SetLogger(log)
+}
 
-	if f.current == "" {
-		return errors.Errorf("No current version specified (-current)")
-	}
-	if f.appName == "" {
-		return errors.Errorf("No app name specified (-app-name)")
+// logLevel is the log level for run (and daemon).
+// With -progress json, progress is written to stderr (where we log), so we
+// only log errors.
+func logLevel(f flags) LogLevel {
+	if f.progress == "json" {
+		return ErrLevel
 	}
+	return InfoLevel
+}
 
-	options := updater.UpdateOptions{
-		AppName:    f.appName,
-		Version:    f.current,
-		Prerelease: f.prerelease,
+func run(ctx context.Context, f flags) error {
+	if f.version {
+		fmt.Printf("%s\n", updater.Version)
+		return nil
 	}
 
-	var src updater.UpdateSource
-	if f.github != "" {
-		src = github.NewUpdateSource(f.github, f.platform)
-	} else {
-		return errors.Errorf("No update source")
-	}
+	setLoggers(NewLogger(logLevel(f)))
 
-	upd := updater.NewUpdater(src)
+	r, err := newRunner(f)
+	if err != nil {
+		return err
+	}
+	defer r.saveState()
 
-	update, err := upd.CheckForUpdate(options)
+	update, err := r.check(ctx)
 	if err != nil {
 		return err
 	}
@@ -101,40 +180,235 @@ func run(f flags) error {
 	checkOnly := !f.download && f.apply == ""
 
 	if checkOnly || !update.NeedUpdate {
-		b, err := json.MarshalIndent(update, "", "  ")
-		if err != nil {
-			return err
-		}
-		fmt.Println(string(b))
-		return nil
+		return printJSON(update)
 	}
 
 	// Download
 	if f.download {
-		if err := upd.Download(update, options); err != nil {
+		if err := r.download(ctx, update); err != nil {
 			return err
 		}
-		if update.Asset != nil {
-			updater.Cleanup(options.AppName, update.Asset.LocalPath)
-		}
 	}
 
 	// Apply
 	if f.apply != "" {
-		localPath := update.Asset.LocalPath
-		if localPath == "" {
-			return errors.Errorf("No local asset to apply, use with -download option?")
-		}
-		if err := apply(options, localPath, f.apply); err != nil {
+		if err := r.apply(update); err != nil {
+			// Report the failed (rolled back) install
+			if _, ok := err.(updater.InstallError); ok {
+				if perr := printJSON(update); perr != nil {
+					return perr
+				}
+			}
 			return err
 		}
-		update.Applied = f.apply
 	}
 
-	b, err := json.MarshalIndent(update, "", "  ")
+	return printJSON(update)
+}
+
+// runner checks for, downloads and applies updates (see run and daemon),
+// recording the results in the updater state.
+type runner struct {
+	f         flags
+	options   updater.UpdateOptions
+	upd       *updater.Updater
+	installer *updater.Installer
+	state     *updater.State
+}
+
+func newRunner(f flags) (*runner, error) {
+	if f.current == "" {
+		return nil, errors.Errorf("No current version specified (-current)")
+	}
+	if f.appName == "" {
+		return nil, errors.Errorf("No app name specified (-app-name)")
+	}
+
+	options := updater.UpdateOptions{
+		AppName:    f.appName,
+		Version:    f.current,
+		Prerelease: f.prerelease,
+		Format:     f.format,
+		Policy: &updater.VersionPolicy{
+			AllowDowngrade: f.downgrade,
+			CompareBuild:   f.compareBuild,
+			Min:            f.minVersion,
+			Max:            f.maxVersion,
+			Skip:           splitList(f.skipVersions),
+		},
+	}
+	if f.channel != "" {
+		channel, err := updater.ParseChannel(f.channel)
+		if err != nil {
+			return nil, err
+		}
+		options.Channel = channel
+	}
+
+	// Finish (or roll back) an install that was interrupted, the health check
+	// (if any) is saved in the install journal
+	dataDir, err := updater.DataDir(options.AppName)
 	if err != nil {
+		return nil, err
+	}
+	installer := updater.NewInstaller(dataDir)
+	if err := installer.Recover(); err != nil {
+		logger.Warningf("Error recovering install: %v", err)
+	}
+
+	state, err := updater.LoadState(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	installID, err := state.EnsureInstallID()
+	if err != nil {
+		return nil, err
+	}
+	options.InstallID = installID
+
+	publicKeys, err := parsePublicKeys(f.publicKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	var src updater.UpdateSource
+	if f.github != "" {
+		src = github.NewUpdateSource(f.github, f.platform, f.arch, publicKeys...)
+	} else if f.url != "" {
+		src = httpjson.NewUpdateSource(f.url, f.platform, f.arch)
+	} else if f.local != "" {
+		src = local.NewUpdateSource(f.local, f.platform)
+	} else {
+		return nil, errors.Errorf("No update source")
+	}
+
+	upd := updater.NewUpdater(src)
+	// Github manifests are signed (and have the asset digest), other sources
+	// have asset signatures
+	if len(publicKeys) > 0 && f.github == "" {
+		upd.SetVerifier(updater.NewEd25519Verifier(publicKeys...))
+	}
+
+	switch f.progress {
+	case "":
+	case "json":
+		upd.SetDownloadProgress(progressJSON(os.Stderr))
+	default:
+		return nil, errors.Errorf("Unsupported progress format: %s", f.progress)
+	}
+
+	return &runner{
+		f:         f,
+		options:   options,
+		upd:       upd,
+		installer: installer,
+		state:     state,
+	}, nil
+}
+
+func (r *runner) saveState() {
+	if err := r.state.Save(); err != nil {
+		logger.Warningf("Error saving state: %v", err)
+	}
+}
+
+func (r *runner) check(ctx context.Context) (*updater.Update, error) {
+	update, err := r.upd.CheckForUpdate(ctx, r.options)
+	r.state.RecordCheck(update, err)
+	return update, err
+}
+
+func (r *runner) download(ctx context.Context, update *updater.Update) error {
+	err := r.upd.Download(ctx, update, r.options)
+	r.state.RecordDownload(update, err)
+	if err != nil {
+		return err
+	}
+	if update.Asset != nil {
+		updater.Cleanup(r.options.AppName, update.Asset.LocalPath)
+	}
+	return nil
+}
+
+// apply a downloaded update.
+// If the install failed, update.ApplyError (and RolledBack) are set.
+func (r *runner) apply(update *updater.Update) error {
+	if err := checkLocalAsset(update); err != nil {
 		return err
 	}
-	fmt.Println(string(b))
+	err := r.install(update)
+	r.recordApply(update, err)
+	return err
+}
+
+// checkLocalAsset returns an error if the update wasn't downloaded.
+func checkLocalAsset(update *updater.Update) error {
+	if update.Asset == nil || update.Asset.LocalPath == "" {
+		return errors.Errorf("No local asset to apply, use with -download option?")
+	}
 	return nil
 }
+
+// install a downloaded update (without recording the result, see apply).
+func (r *runner) install(update *updater.Update) error {
+	if r.f.healthCheck != "" {
+		r.installer.VersionCheck = &updater.VersionCheck{
+			Version:    update.Version,
+			Executable: r.f.healthCheckExec,
+			Args:       strings.Fields(r.f.healthCheck),
+			Timeout:    r.f.healthCheckTimeout,
+		}
+	}
+	return apply(r.options, r.installer, update.Asset.LocalPath, r.f.apply)
+}
+
+// recordApply records the result of an install in the state and update.
+func (r *runner) recordApply(update *updater.Update, err error) {
+	r.state.RecordApply(update, r.f.apply, err)
+	if err != nil {
+		if ierr, ok := err.(updater.InstallError); ok {
+			update.ApplyError = ierr.Err.Error()
+			update.RolledBack = ierr.RolledBack
+		}
+		return
+	}
+	update.Applied = r.f.apply
+}
+
+// splitList splits a comma separated list, ignoring empty values.
+func splitList(s string) []string {
+	list := []string{}
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+func parsePublicKeys(s string) ([]ed25519.PublicKey, error) {
+	publicKeys := []ed25519.PublicKey{}
+	for _, k := range splitList(s) {
+		publicKey, err := updater.ParseEd25519PublicKey(k)
+		if err != nil {
+			return nil, err
+		}
+		publicKeys = append(publicKeys, publicKey)
+	}
+	return publicKeys, nil
+}
+
+type progressEvent struct {
+	Type string `json:"type"`
+	util.Progress
+}
+
+// progressJSON writes progress events as line-delimited JSON.
+func progressJSON(w io.Writer) util.ProgressFn {
+	enc := json.NewEncoder(w)
+	return func(p util.Progress) {
+		if err := enc.Encode(progressEvent{Type: "progress", Progress: p}); err != nil {
+			logger.Warningf("Error writing progress: %v", err)
+		}
+	}
+}
@@ -4,8 +4,23 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	pkglog "log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/keys-pub/updater"
+	"github.com/keys-pub/updater/util"
 	"github.com/stretchr/testify/require"
 )
 
@@ -13,16 +28,133 @@ import (
 
 func TestRun(t *testing.T) {
 	f := flags{current: "0.0.1", appName: "Keys", github: "keys-pub/app"}
-	err := run(f)
+	err := run(context.TODO(), f)
 	require.NoError(t, err)
 
 	f = flags{current: "0.0.1", appName: "Keys", github: "keys-pub/app", download: true}
-	err = run(f)
+	err = run(context.TODO(), f)
 	require.NoError(t, err)
 }
 
 func TestRunPrerelease(t *testing.T) {
 	f := flags{current: "0.0.1", appName: "Keys", github: "keys-pub/app", prerelease: true}
-	err := run(f)
+	err := run(context.TODO(), f)
 	require.NoError(t, err)
 }
+
+func TestProgressJSON(t *testing.T) {
+	var buf bytes.Buffer
+	fn := progressJSON(&buf)
+	fn(util.Progress{Done: 10, Total: 100, Rate: 5})
+	fn(util.Progress{Done: 100, Total: 100, Rate: 5})
+	require.Equal(t, `{"type":"progress","done":10,"total":100,"rate":5}
+{"type":"progress","done":100,"total":100,"rate":5}
+`, buf.String())
+}
+
+func TestRunCache(t *testing.T) {
+	err := runCache([]string{"list", "-app-name", "TestRunCache"})
+	require.NoError(t, err)
+	err = runCache([]string{"prune", "-app-name", "TestRunCache", "-retain", "2"})
+	require.NoError(t, err)
+	err = runCache([]string{"clear", "-app-name", "TestRunCache"})
+	require.NoError(t, err)
+
+	err = runCache([]string{"list"})
+	require.EqualError(t, err, "No app name specified (-app-name)")
+	err = runCache([]string{"invalid", "-app-name", "TestRunCache"})
+	require.EqualError(t, err, "Unknown cache command: invalid")
+}
+
+func TestRunStatus(t *testing.T) {
+	err := runStatus([]string{"-app-name", "TestRunStatus"})
+	require.NoError(t, err)
+
+	err = runStatus([]string{})
+	require.EqualError(t, err, "No app name specified (-app-name)")
+}
+
+func TestRunnerPublicKeys(t *testing.T) {
+	dir, err := util.MakeTempDir("TestRunnerPublicKeys.", 0700)
+	require.NoError(t, err)
+	defer util.RemoveFileAtPath(dir)
+	defer updater.NewCache("TestRunnerPublicKeys").Clear()
+	err = util.CopyFile("../../test/test.zip", filepath.Join(dir, "test.zip"))
+	require.NoError(t, err)
+
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	otherPublicKey, _, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	f, err := os.Open(filepath.Join(dir, "test.zip"))
+	require.NoError(t, err)
+	sig, err := updater.Ed25519Sign(privateKey, f)
+	require.NoError(t, f.Close())
+	require.NoError(t, err)
+	update := fmt.Sprintf(`{"version": "1.0.1", "asset": {"name": "test.zip", "url": "test.zip", "digest": "54970995e4d02da631e0634162ef66e2663e0eee7d018e816ac48ed6f7811c84", "signature": %q}}`, sig)
+	err = ioutil.WriteFile(filepath.Join(dir, "update-linux.json"), []byte(update), 0600)
+	require.NoError(t, err)
+
+	download := func(publicKey ed25519.PublicKey) error {
+		r, err := newRunner(flags{appName: "TestRunnerPublicKeys", current: "1.0.0", local: dir, platform: "linux", publicKeys: base64.StdEncoding.EncodeToString(publicKey)})
+		require.NoError(t, err)
+		r.state, err = updater.LoadState(dir)
+		require.NoError(t, err)
+		upd, err := r.check(context.TODO())
+		require.NoError(t, err)
+		require.True(t, upd.NeedUpdate)
+		return r.download(context.TODO(), upd)
+	}
+
+	err = download(publicKey)
+	require.NoError(t, err)
+	updater.NewCache("TestRunnerPublicKeys").Clear()
+	err = download(otherPublicKey)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "Unknown signature key")
+}
+
+func TestRunProgressJSON(t *testing.T) {
+	dir, err := util.MakeTempDir("TestRunProgressJSON.", 0700)
+	require.NoError(t, err)
+	defer util.RemoveFileAtPath(dir)
+	defer updater.NewCache("TestRunProgressJSON").Clear()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/update.json":
+			_, _ = w.Write([]byte(`{"version": "1.0.1", "asset": {"name": "test.zip", "url": "test.zip", "digest": "54970995e4d02da631e0634162ef66e2663e0eee7d018e816ac48ed6f7811c84"}}`))
+		case "/test.zip":
+			http.ServeFile(w, r, "../../test/test.zip")
+		default:
+			http.Error(w, "Not Found", 404)
+		}
+	}))
+	defer server.Close()
+
+	// Capture stderr (and the log, which was set to stderr on init)
+	stderrPath := filepath.Join(dir, "stderr")
+	stderr, err := os.Create(stderrPath)
+	require.NoError(t, err)
+	defer stderr.Close()
+	origStderr := os.Stderr
+	os.Stderr = stderr
+	pkglog.SetOutput(stderr)
+	defer func() {
+		os.Stderr = origStderr
+		pkglog.SetOutput(origStderr)
+	}()
+
+	f := flags{current: "1.0.0", appName: "TestRunProgressJSON", url: server.URL + "/update.json", download: true, progress: "json"}
+	err = run(context.TODO(), f)
+	require.NoError(t, err)
+
+	b, err := ioutil.ReadFile(stderrPath)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	require.NotEmpty(t, lines[0])
+	for _, line := range lines {
+		var event progressEvent
+		require.NoError(t, json.Unmarshal([]byte(line), &event), line)
+		require.Equal(t, "progress", event.Type)
+	}
+}
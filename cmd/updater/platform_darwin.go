@@ -1,7 +1,6 @@
 package main
 
 import (
-	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
@@ -18,7 +17,7 @@ func checkDestination(options updater.UpdateOptions, dir string, file string) er
 	return nil
 }
 
-func apply(options updater.UpdateOptions, assetPath string, applyPath string) error {
+func apply(options updater.UpdateOptions, installer *updater.Installer, assetPath string, applyPath string) error {
 	destinationDir, destinationFile := filepath.Split(applyPath)
 	if err := checkDestination(options, destinationDir, destinationFile); err != nil {
 		return err
@@ -35,17 +34,5 @@ func apply(options updater.UpdateOptions, assetPath string, applyPath string) er
 	}
 	logger.Debugf("%s", out)
 
-	if _, err := os.Stat(applyPath); err == nil {
-		logger.Infof("Removing existing %s", applyPath)
-		if err := os.RemoveAll(applyPath); err != nil {
-			return err
-		}
-	}
-	path := filepath.Join(sourceDir, destinationFile)
-	logger.Infof("Moving %s to %s", path, applyPath)
-	if err := os.Rename(path, applyPath); err != nil {
-		return err
-	}
-
-	return nil
+	return installer.Install(filepath.Join(sourceDir, destinationFile), applyPath)
 }
@@ -1,10 +1,92 @@
 package main
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
 	"github.com/keys-pub/updater"
+	"github.com/keys-pub/updater/util"
 	"github.com/pkg/errors"
 )
 
-func apply(options updater.UpdateOptions, sourcePath string, destinationPath string) error {
-	return errors.Errorf("Unsupported platform")
+func checkDestination(options updater.UpdateOptions, dir string, file string) error {
+	if file == "" || file == "." || file == string(filepath.Separator) {
+		return errors.Errorf("invalid destination file: %s", file)
+	}
+	if !filepath.IsAbs(dir) {
+		return errors.Errorf("invalid destination dir: %s", dir)
+	}
+	if _, err := util.IsDirReal(dir); err != nil {
+		return errors.Wrapf(err, "invalid destination dir: %s", dir)
+	}
+	return nil
+}
+
+func apply(options updater.UpdateOptions, installer *updater.Installer, assetPath string, applyPath string) error {
+	applyPath = filepath.Clean(applyPath)
+	destinationDir, destinationFile := filepath.Split(applyPath)
+	if err := checkDestination(options, destinationDir, destinationFile); err != nil {
+		return err
+	}
+
+	// Stage next to the destination so the final rename is on the same
+	// filesystem (and atomic).
+	stagePath := applyPath + ".new"
+	util.RemoveFileAtPath(stagePath)
+	defer util.RemoveFileAtPath(stagePath)
+
+	if err := stage(assetPath, destinationFile, stagePath); err != nil {
+		return err
+	}
+
+	return installer.Install(stagePath, applyPath)
+}
+
+// stage prepares the contents of the asset at stagePath.
+// AppImage (or other single file) assets are copied as an executable,
+// archives are extracted and the install directory is picked from them.
+func stage(assetPath string, destinationFile string, stagePath string) error {
+	if util.ArchiveFormatForName(filepath.Base(assetPath)) != "" {
+		extractPath, err := util.ExtractPath(assetPath)
+		defer util.RemoveFileAtPath(extractPath)
+		if err != nil {
+			return err
+		}
+		return stageExtracted(extractPath, destinationFile, stagePath)
+	}
+	if err := util.CopyFile(assetPath, stagePath); err != nil {
+		return err
+	}
+	return os.Chmod(stagePath, 0755)
+}
+
+// stageExtracted moves the install directory from an extracted archive to
+// stagePath.
+// If the archive has an entry named like the destination, we use that,
+// if it has a single top level directory we use that, otherwise we use the
+// whole extracted directory.
+func stageExtracted(extractPath string, destinationFile string, stagePath string) error {
+	sourcePath := filepath.Join(extractPath, destinationFile)
+	if exists, err := util.FileExists(sourcePath); err != nil {
+		return err
+	} else if !exists {
+		sourcePath = extractPath
+		files, err := ioutil.ReadDir(extractPath)
+		if err != nil {
+			return err
+		}
+		entries := []os.FileInfo{}
+		for _, f := range files {
+			if f.Name() == "__MACOSX" {
+				continue
+			}
+			entries = append(entries, f)
+		}
+		if len(entries) == 1 && entries[0].IsDir() {
+			sourcePath = filepath.Join(extractPath, entries[0].Name())
+		}
+	}
+	logger.Infof("Staging %s to %s", sourcePath, stagePath)
+	return os.Rename(sourcePath, stagePath)
 }
@@ -0,0 +1,123 @@
+// +build linux
+
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/keys-pub/updater"
+	"github.com/keys-pub/updater/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckDestination(t *testing.T) {
+	dir, file := filepath.Split("/tmp/Keys")
+	err := checkDestination(updater.UpdateOptions{}, dir, file)
+	require.NoError(t, err)
+
+	dir, file = filepath.Split("/")
+	err = checkDestination(updater.UpdateOptions{}, dir, file)
+	require.EqualError(t, err, "invalid destination file: ")
+
+	dir, file = filepath.Split("Keys")
+	err = checkDestination(updater.UpdateOptions{}, dir, file)
+	require.EqualError(t, err, "invalid destination dir: ")
+}
+
+func TestApplyZip(t *testing.T) {
+	tmpDir, err := util.MakeTempDir("TestApplyZip.", 0700)
+	require.NoError(t, err)
+	defer util.RemoveFileAtPath(tmpDir)
+
+	assetPath := filepath.Join(tmpDir, "test.zip")
+	err = util.CopyFile("../../test/test.zip", assetPath)
+	require.NoError(t, err)
+
+	applyPath := filepath.Join(tmpDir, "test")
+	err = ioutil.WriteFile(applyPath, []byte("old"), 0600)
+	require.NoError(t, err)
+
+	err = apply(updater.UpdateOptions{}, updater.NewInstaller(tmpDir), assetPath, applyPath)
+	require.NoError(t, err)
+
+	b, err := ioutil.ReadFile(filepath.Join(applyPath, "testfile"))
+	require.NoError(t, err)
+	require.NotEmpty(t, b)
+
+	// Only the new install is left
+	requireNotExists(t, applyPath+".old")
+	requireNotExists(t, applyPath+".new")
+}
+
+func requireNotExists(t *testing.T, path string) {
+	exists, err := util.FileExists(path)
+	require.NoError(t, err)
+	require.False(t, exists, "%s exists", path)
+}
+
+func TestApplyTarXz(t *testing.T) {
+	tmpDir, err := util.MakeTempDir("TestApplyTarXz.", 0700)
+	require.NoError(t, err)
+	defer util.RemoveFileAtPath(tmpDir)
+
+	assetPath := filepath.Join(tmpDir, "test.tar.xz")
+	err = util.CopyFile("../../test/test.tar.xz", assetPath)
+	require.NoError(t, err)
+
+	applyPath := filepath.Join(tmpDir, "test")
+	err = apply(updater.UpdateOptions{}, updater.NewInstaller(tmpDir), assetPath, applyPath)
+	require.NoError(t, err)
+
+	b, err := ioutil.ReadFile(filepath.Join(applyPath, "testfolder", "testsubfolder", "testfile2"))
+	require.NoError(t, err)
+	require.NotEmpty(t, b)
+}
+
+func TestApplyAppImage(t *testing.T) {
+	tmpDir, err := util.MakeTempDir("TestApplyAppImage.", 0700)
+	require.NoError(t, err)
+	defer util.RemoveFileAtPath(tmpDir)
+
+	assetPath := filepath.Join(tmpDir, "Keys-0.0.18.AppImage")
+	err = ioutil.WriteFile(assetPath, []byte("new"), 0600)
+	require.NoError(t, err)
+
+	applyPath := filepath.Join(tmpDir, "Keys.AppImage")
+	err = apply(updater.UpdateOptions{}, updater.NewInstaller(tmpDir), assetPath, applyPath)
+	require.NoError(t, err)
+
+	exists, err := util.FileExists(applyPath + ".new")
+	require.NoError(t, err)
+	require.False(t, exists)
+	b, err := ioutil.ReadFile(applyPath)
+	require.NoError(t, err)
+	require.Equal(t, "new", string(b))
+}
+
+func TestApplyHealthCheck(t *testing.T) {
+	tmpDir, err := util.MakeTempDir("TestApplyHealthCheck.", 0700)
+	require.NoError(t, err)
+	defer util.RemoveFileAtPath(tmpDir)
+
+	assetPath := filepath.Join(tmpDir, "Test-1.2.3.AppImage")
+	err = util.CopyFile("../../test/test.linux", assetPath)
+	require.NoError(t, err)
+	applyPath := filepath.Join(tmpDir, "Test.AppImage")
+	err = ioutil.WriteFile(applyPath, []byte("old"), 0700)
+	require.NoError(t, err)
+
+	installer := updater.NewInstaller(tmpDir)
+	installer.HealthCheck = updater.VersionCheck{Version: "1.2.4", Args: []string{"version"}}.Check
+	err = apply(updater.UpdateOptions{}, installer, assetPath, applyPath)
+	require.EqualError(t, err, `Install failed (rolled back): Health check failed: Version mismatch, expected 1.2.4, got "1.2.3-400+cafebeef"`)
+	b, err := ioutil.ReadFile(applyPath)
+	require.NoError(t, err)
+	require.Equal(t, "old", string(b))
+
+	installer.HealthCheck = updater.VersionCheck{Version: "1.2.3-400+cafebeef", Args: []string{"version"}}.Check
+	err = apply(updater.UpdateOptions{}, installer, assetPath, applyPath)
+	require.NoError(t, err)
+	requireNotExists(t, applyPath+".old")
+}
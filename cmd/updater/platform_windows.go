@@ -7,12 +7,15 @@ import (
 	"github.com/pkg/errors"
 )
 
-func apply(options updater.UpdateOptions, assetPath string, applyPath string) error {
+// apply runs msiexec and waits for it to finish.
+// The installer isn't used (msiexec installs to its own location), so there's
+// no health check or rollback on windows.
+func apply(options updater.UpdateOptions, installer *updater.Installer, assetPath string, applyPath string) error {
 	logger.Infof("Running msiexec.exe -i %s", assetPath)
-	cmd := exec.Command("msiexec.exe", "-i", assetPath)
-	if err := cmd.Start(); err != nil {
-		return errors.Wrapf(err, "Command failed (%s)", assetPath)
+	out, err := exec.Command("msiexec.exe", "-i", assetPath).CombinedOutput()
+	if err != nil {
+		return errors.Wrapf(err, "Command failed (%s): %s", assetPath, out)
 	}
-
+	logger.Debugf("%s", out)
 	return nil
 }
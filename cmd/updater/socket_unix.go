@@ -0,0 +1,48 @@
+// +build !windows
+
+package main
+
+import (
+	"net"
+	"os"
+	"syscall"
+
+	"github.com/keys-pub/updater/util"
+	"github.com/pkg/errors"
+)
+
+// checkSocketDir returns an error if the control socket directory isn't
+// owned by us, or is accessible by other users.
+func checkSocketDir(dir string) error {
+	fileInfo, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !fileInfo.IsDir() {
+		return errors.Errorf("Socket directory isn't a directory: %s", dir)
+	}
+	if stat, ok := fileInfo.Sys().(*syscall.Stat_t); ok && int(stat.Uid) != os.Getuid() {
+		return errors.Errorf("Socket directory isn't owned by us (uid %d): %s", stat.Uid, dir)
+	}
+	if perm := fileInfo.Mode().Perm(); perm&0077 != 0 {
+		return errors.Errorf("Socket directory is accessible by other users (%s): %s", perm, dir)
+	}
+	return nil
+}
+
+// listenSocket listens on a unix socket, only accessible by us.
+func listenSocket(socketPath string) (net.Listener, error) {
+	// Create the socket without group or other permissions (before the
+	// chmod below)
+	umask := syscall.Umask(0077)
+	ln, err := net.Listen("unix", socketPath)
+	syscall.Umask(umask)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(socketPath, 0600); err != nil {
+		util.Close(ln)
+		return nil, err
+	}
+	return ln, nil
+}
@@ -0,0 +1,32 @@
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/keys-pub/updater/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListenUnixPermissions(t *testing.T) {
+	dir, err := util.MakeTempDir("TestListenUnix.", 0700)
+	require.NoError(t, err)
+	defer util.RemoveFileAtPath(dir)
+
+	socketPath := filepath.Join(dir, "updater.sock")
+	ln, err := listenUnix(socketPath)
+	require.NoError(t, err)
+	fileInfo, err := os.Stat(socketPath)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), fileInfo.Mode().Perm())
+	ln.Close()
+
+	// Directory accessible by other users
+	err = os.Chmod(dir, 0755)
+	require.NoError(t, err)
+	_, err = listenUnix(socketPath)
+	require.EqualError(t, err, "Socket directory is accessible by other users (-rwxr-xr-x): "+dir)
+}
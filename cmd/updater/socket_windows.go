@@ -0,0 +1,23 @@
+package main
+
+import (
+	"net"
+	"os"
+)
+
+// checkSocketDir is a no-op on windows (no unix permissions to check).
+func checkSocketDir(dir string) error {
+	return nil
+}
+
+// listenSocket listens on a unix socket.
+func listenSocket(socketPath string) (net.Listener, error) {
+	ln, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(socketPath, 0600); err != nil {
+		logger.Warningf("Error setting control socket permissions: %v", err)
+	}
+	return ln, nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"flag"
+
+	"github.com/keys-pub/updater"
+	"github.com/pkg/errors"
+)
+
+// runStatus prints the updater state (as JSON), for example:
+//
+//   updater status -app-name Keys
+//
+func runStatus(args []string) error {
+	var appName string
+	fs := flag.NewFlagSet("status", flag.ContinueOnError)
+	fs.StringVar(&appName, "app-name", "", "App name")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if appName == "" {
+		return errors.Errorf("No app name specified (-app-name)")
+	}
+
+	dataDir, err := updater.DataDir(appName)
+	if err != nil {
+		return err
+	}
+	state, err := updater.LoadState(dataDir)
+	if err != nil {
+		return err
+	}
+	return printJSON(state)
+}
@@ -0,0 +1,97 @@
+package github
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// archNames are the names electron-builder (and Linux packages) use for an
+// architecture (GOARCH) in file names.
+var archNames = map[string][]string{
+	"amd64": {"x64", "x86_64", "amd64"},
+	"386":   {"ia32", "i386", "i686"},
+	"arm64": {"arm64", "aarch64"},
+	"arm":   {"armv7l", "armhf"},
+}
+
+// defaultFormat is the format we can apply for a platform (on windows, we
+// install with msiexec).
+func defaultFormat(platform string) string {
+	switch platform {
+	case "darwin":
+		return "zip"
+	case "linux":
+		return "AppImage"
+	case "windows":
+		return "msi"
+	default:
+		return ""
+	}
+}
+
+// fileArch returns the architecture (GOARCH) in a file name, "universal", or
+// "" if the name doesn't have one.
+func fileArch(name string) string {
+	words := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return r == '-' || r == '_' || r == '.'
+	})
+	for _, word := range words {
+		if word == "universal" {
+			return word
+		}
+		for arch, names := range archNames {
+			for _, n := range names {
+				if word == n {
+					return arch
+				}
+			}
+		}
+	}
+	return ""
+}
+
+func hasFormat(name string, format string) bool {
+	return strings.HasSuffix(strings.ToLower(name), "."+strings.ToLower(format))
+}
+
+// selectFile returns the manifest file for an architecture (GOARCH) and
+// format (file extension, for example, zip, dmg or AppImage).
+// If format is empty, we use the platform's default format.
+// We prefer a file for the architecture, then a universal file.
+// A file without an architecture in its name is for amd64 (as
+// electron-builder names them).
+// If the manifest doesn't list files, we check the manifest path.
+func (s githubSource) selectFile(gupd update, format string) (file, error) {
+	if format == "" {
+		format = defaultFormat(s.platform)
+	}
+	files := gupd.Files
+	if len(files) == 0 && gupd.Path != "" {
+		files = []file{{URL: gupd.Path, SHA512: gupd.SHA512}}
+	}
+
+	var universal *file
+	for i := range files {
+		f := &files[i]
+		if !hasFormat(f.URL, format) {
+			continue
+		}
+		arch := fileArch(f.URL)
+		if arch == "" {
+			arch = "amd64"
+		}
+		switch arch {
+		case s.arch:
+			return *f, nil
+		case "universal":
+			if universal == nil {
+				universal = f
+			}
+		}
+	}
+	if universal != nil {
+		return *universal, nil
+	}
+	return file{}, errors.Errorf("No %s file for %s", format, s.arch)
+}
@@ -4,6 +4,9 @@
 package github
 
 import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
@@ -11,6 +14,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strings"
 	"time"
 
 	"github.com/blang/semver"
@@ -23,12 +27,14 @@ import (
 type githubSource struct {
 	repo     string
 	platform string
+	arch     string
+	verifier *updater.Ed25519Verifier
 }
 
 type file struct {
 	URL          string `yaml:"url"`
 	SHA512       string `yaml:"sha512"`
-	Size         int    `yaml:"size"`
+	Size         int64  `yaml:"size"`
 	BlockMapSize int    `yaml:"blockMapSize"`
 }
 
@@ -37,24 +43,35 @@ type update struct {
 	Path        string `yaml:"path"`
 	SHA512      string `yaml:"sha512"`
 	ReleaseDate string `yaml:"releaseDate"`
-	Files       []file `yaml:"files"`
+	// StagingPercentage is the percentage of installs to roll out to.
+	StagingPercentage *int   `yaml:"stagingPercentage"`
+	Files             []file `yaml:"files"`
 }
 
 // NewUpdateSource returns Github update source.
-func NewUpdateSource(repo string, platform string) updater.UpdateSource {
-	return newGithubSource(repo, platform)
+// The update asset is the file in the manifest for arch (GOARCH) and
+// UpdateOptions.Format, see selectFile.
+// If publicKeys are specified, the manifest must have a valid detached
+// signature (see updater.Ed25519Sign) at the manifest URL with a ".sig"
+// extension, for example, latest-mac.yml.sig.
+func NewUpdateSource(repo string, platform string, arch string, publicKeys ...ed25519.PublicKey) updater.UpdateSource {
+	return newGithubSource(repo, platform, arch, publicKeys...)
 }
 
-func newGithubSource(repo string, platform string) githubSource {
-	return githubSource{repo: repo, platform: platform}
+func newGithubSource(repo string, platform string, arch string, publicKeys ...ed25519.PublicKey) githubSource {
+	s := githubSource{repo: repo, platform: platform, arch: arch}
+	if len(publicKeys) > 0 {
+		s.verifier = updater.NewEd25519Verifier(publicKeys...)
+	}
+	return s
 }
 
 func (s githubSource) Description() string {
 	return fmt.Sprintf("github.com/%s", s.repo)
 }
 
-func (s githubSource) FindUpdate(options updater.UpdateOptions) (*updater.Update, error) {
-	return s.findUpdate(options, time.Minute)
+func (s githubSource) FindUpdate(ctx context.Context, options updater.UpdateOptions) (*updater.Update, error) {
+	return s.findUpdate(ctx, options, time.Minute)
 }
 
 func base64ToHex(s string) (string, error) {
@@ -77,32 +94,63 @@ func (s githubSource) updateFromGithub(b []byte, options updater.UpdateOptions)
 	}
 	ts := util.TimeToMillis(t)
 
-	digest, err := base64ToHex(gupd.SHA512)
+	f, err := s.selectFile(gupd, options.Format)
 	if err != nil {
 		return nil, err
 	}
 
-	url := fmt.Sprintf("https://github.com/%s/releases/download/v%s/%s", s.repo, gupd.Version, gupd.Path)
+	digest, err := base64ToHex(f.SHA512)
+	if err != nil {
+		return nil, err
+	}
 
-	curr, err := semver.Make(options.Version)
-	next, err := semver.Make(gupd.Version)
-	needUpdate := curr.LT(next)
+	url := s.assetURL(gupd.Version, f.URL)
+
+	needUpdate, err := updater.NeedUpdate(options, gupd.Version)
+	if err != nil {
+		return nil, err
+	}
 
 	uu := &updater.Update{
 		Version:     gupd.Version,
 		PublishedAt: int64(ts),
 		Asset: &updater.Asset{
-			Name:       gupd.Path,
+			Name:       f.URL,
 			URL:        url,
 			Digest:     digest,
 			DigestType: "sha512",
+			Size:       f.Size,
 			// Signature: "",
 		},
+		Rollout:    gupd.StagingPercentage,
 		NeedUpdate: needUpdate,
 	}
+	if needUpdate {
+		uu.Asset.Differential = s.differential(gupd.Version, f, options)
+	}
 	return uu, nil
 }
 
+// differential returns blockmap info for the update file (if available), so
+// we can download only the blocks that changed since the current version.
+// This assumes the current version's asset is named like the update's, for
+// example, Keys-0.0.17-mac.zip and Keys-0.0.18-mac.zip.
+func (s githubSource) differential(version string, f file, options updater.UpdateOptions) *updater.Differential {
+	if !strings.Contains(f.URL, version) || f.BlockMapSize == 0 {
+		return nil
+	}
+	previousName := strings.Replace(f.URL, version, options.Version, -1)
+	return &updater.Differential{
+		BlockMapURL:         s.assetURL(version, f.URL+".blockmap"),
+		PreviousName:        previousName,
+		PreviousBlockMapURL: s.assetURL(options.Version, previousName+".blockmap"),
+	}
+}
+
+func (s githubSource) assetURL(version string, name string) string {
+	return fmt.Sprintf("https://github.com/%s/releases/download/v%s/%s", s.repo, version, name)
+}
+
 func (s githubSource) latestManifestURL() (string, error) {
 	switch s.platform {
 	case "darwin":
@@ -131,12 +179,44 @@ func (s githubSource) tagManifestURL(tag string) (string, error) {
 
 type release struct {
 	Prerelease bool   `json:"prerelease"`
+	Draft      bool   `json:"draft"`
 	Name       string `json:"name"`
 	Tag        string `json:"tag_name"`
 }
 
-func (s githubSource) prereleaseURL(timeout time.Duration) (string, error) {
-	b, err := request(fmt.Sprintf("https://api.github.com/repos/%s/releases", s.repo), timeout)
+// bestRelease returns the release with the highest version in the channel
+// (and allowed by the version policy), or nil if there isn't one.
+// Releases marked prerelease on Github, without a channel in the version
+// (for example, v1.2.3), are considered beta.
+func bestRelease(rels []*release, options updater.UpdateOptions) *release {
+	channel := options.ReleaseChannel()
+	var best *release
+	var bestVersion semver.Version
+	for _, rel := range rels {
+		if rel.Draft || rel.Tag == "" {
+			continue
+		}
+		version, err := semver.Make(strings.TrimPrefix(rel.Tag, "v"))
+		if err != nil {
+			logger.Debugf("Skipping release %s: %v", rel.Tag, err)
+			continue
+		}
+		if rel.Prerelease && channel == updater.Stable {
+			continue
+		}
+		if ok, err := options.Allows(version); err != nil || !ok {
+			continue
+		}
+		if best == nil || version.GT(bestVersion) {
+			best = rel
+			bestVersion = version
+		}
+	}
+	return best
+}
+
+func (s githubSource) channelURL(ctx context.Context, options updater.UpdateOptions, timeout time.Duration) (string, error) {
+	b, err := request(ctx, fmt.Sprintf("https://api.github.com/repos/%s/releases", s.repo), timeout)
 	if err != nil {
 		return "", err
 	}
@@ -145,29 +225,22 @@ func (s githubSource) prereleaseURL(timeout time.Duration) (string, error) {
 	if err := json.Unmarshal(b, &rels); err != nil {
 		return "", err
 	}
-	if len(rels) == 0 {
-		return "", nil
-	}
 
-	rel := rels[0]
-
-	if !rel.Prerelease {
+	rel := bestRelease(rels, options)
+	if rel == nil {
 		return "", nil
 	}
-
-	if rel.Tag == "" {
-		return "", errors.Errorf("no tag for release")
-	}
-
 	return s.tagManifestURL(rel.Tag)
 }
 
-func (s githubSource) findManifestURL(prerelease bool, timeout time.Duration) (string, error) {
-	if prerelease {
-		urs, err := s.prereleaseURL(timeout)
-		// If prelease not found or errored, fall back to latest
-		if err != nil {
-			logger.Infof("Error checking for prerelease: %v", err)
+func (s githubSource) findManifestURL(ctx context.Context, options updater.UpdateOptions, timeout time.Duration) (string, error) {
+	if channel := options.ReleaseChannel(); channel != updater.Stable {
+		urs, err := s.channelURL(ctx, options, timeout)
+		// If release not found or errored, fall back to latest
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		} else if err != nil {
+			logger.Infof("Error checking for %s release: %v", channel, err)
 		} else if urs != "" {
 			return urs, nil
 		}
@@ -176,12 +249,12 @@ func (s githubSource) findManifestURL(prerelease bool, timeout time.Duration) (s
 	return s.latestManifestURL()
 }
 
-func (s githubSource) findUpdate(options updater.UpdateOptions, timeout time.Duration) (*updater.Update, error) {
+func (s githubSource) findUpdate(ctx context.Context, options updater.UpdateOptions, timeout time.Duration) (*updater.Update, error) {
 	if s.repo == "" {
 		return nil, errors.Errorf("No repo specified")
 	}
 
-	manifestURL, err := s.findManifestURL(options.Prerelease, timeout)
+	manifestURL, err := s.findManifestURL(ctx, options, timeout)
 	if err != nil {
 		return nil, err
 	}
@@ -197,11 +270,22 @@ func (s githubSource) findUpdate(options updater.UpdateOptions, timeout time.Dur
 	urs := ur.String()
 	logger.Infof("Requesting %s", urs)
 
-	b, err := request(urs, timeout)
+	b, err := request(ctx, urs, timeout)
 	if err != nil {
 		return nil, err
 	}
 
+	if s.verifier != nil {
+		logger.Infof("Requesting %s.sig", urs)
+		sig, err := request(ctx, urs+".sig", timeout)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to get manifest signature")
+		}
+		if err := s.verifyManifest(b, sig); err != nil {
+			return nil, err
+		}
+	}
+
 	uu, err := s.updateFromGithub(b, options)
 	if err != nil {
 		return nil, err
@@ -211,8 +295,26 @@ func (s githubSource) findUpdate(options updater.UpdateOptions, timeout time.Dur
 	return uu, nil
 }
 
-func request(urs string, timeout time.Duration) ([]byte, error) {
-	req, err := http.NewRequest("GET", urs, nil)
+func (s githubSource) verifyManifest(b []byte, sig []byte) error {
+	if err := s.verifier.VerifySignature(string(sig), bytes.NewReader(b)); err != nil {
+		return errors.Wrapf(err, "Failed to verify manifest")
+	}
+	return nil
+}
+
+// request a URL, retrying (see util.DefaultRetryPolicy).
+func request(ctx context.Context, urs string, timeout time.Duration) ([]byte, error) {
+	var b []byte
+	err := util.DefaultRetryPolicy.Do(ctx, func() error {
+		var err error
+		b, err = requestOnce(ctx, urs, timeout)
+		return err
+	})
+	return b, err
+}
+
+func requestOnce(ctx context.Context, urs string, timeout time.Duration) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", urs, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -225,7 +327,7 @@ func request(urs string, timeout time.Duration) ([]byte, error) {
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("Find update returned bad HTTP status %v", resp.Status)
+		return nil, errors.Wrapf(util.NewHTTPStatusError(resp), "Find update returned bad HTTP status")
 	}
 
 	return ioutil.ReadAll(resp.Body)
@@ -1,6 +1,10 @@
 package github
 
 import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"fmt"
 	"io/ioutil"
 	"strings"
 	"testing"
@@ -11,7 +15,7 @@ import (
 )
 
 func TestUpdate(t *testing.T) {
-	s := newGithubSource("keys-pub/app", "darwin")
+	s := newGithubSource("keys-pub/app", "darwin", "amd64")
 	b, err := ioutil.ReadFile("./testdata/latest-mac.yml")
 	require.NoError(t, err)
 	upd, err := s.updateFromGithub(b, updater.UpdateOptions{Version: "0.0.17"})
@@ -20,28 +24,193 @@ func TestUpdate(t *testing.T) {
 	require.Equal(t, int64(1583275443689), upd.PublishedAt)
 	require.NotNil(t, upd.Asset)
 	require.Equal(t, "Keys-0.0.18-mac.zip", upd.Asset.Name)
-	require.Equal(t, "https://github.com/keys-pub/app/releases/latest/download/Keys-0.0.18-mac.zip", upd.Asset.URL)
+	require.Equal(t, "https://github.com/keys-pub/app/releases/download/v0.0.18/Keys-0.0.18-mac.zip", upd.Asset.URL)
 	require.Equal(t, "9fe462603acbd84e55e5dfa6a02f40d0483551c88bd053b4b3827aba67d7fe3e53414a2214f6387a02e0bfc667d464ed0cc494f14b6ca04ae5ca81a20d503618", upd.Asset.Digest)
+	require.Equal(t, int64(87933949), upd.Asset.Size)
 	require.True(t, upd.NeedUpdate)
+	require.Equal(t, &updater.Differential{
+		BlockMapURL:         "https://github.com/keys-pub/app/releases/download/v0.0.18/Keys-0.0.18-mac.zip.blockmap",
+		PreviousName:        "Keys-0.0.17-mac.zip",
+		PreviousBlockMapURL: "https://github.com/keys-pub/app/releases/download/v0.0.17/Keys-0.0.17-mac.zip.blockmap",
+	}, upd.Asset.Differential)
 
 	upd, err = s.updateFromGithub(b, updater.UpdateOptions{Version: "0.0.18"})
 	require.NoError(t, err)
 	require.False(t, upd.NeedUpdate)
+	require.Nil(t, upd.Asset.Differential)
 
 	upd, err = s.updateFromGithub(b, updater.UpdateOptions{Version: "0.0.19"})
 	require.NoError(t, err)
 	require.False(t, upd.NeedUpdate)
+
+	_, err = s.updateFromGithub(b, updater.UpdateOptions{Version: "garbage"})
+	require.EqualError(t, err, `Invalid current version "garbage": No Major.Minor.Patch elements found`)
+}
+
+func TestSelectFile(t *testing.T) {
+	gupd := update{
+		Version: "0.0.18",
+		Path:    "Keys-0.0.18-mac.zip",
+		SHA512:  "abc",
+		Files: []file{
+			{URL: "Keys-0.0.18-mac.zip", Size: 1},
+			{URL: "Keys-0.0.18-arm64-mac.zip", Size: 2},
+			{URL: "Keys-0.0.18.dmg", Size: 3},
+			{URL: "Keys-0.0.18-arm64.dmg", Size: 4},
+		},
+	}
+	f, err := newGithubSource("keys-pub/app", "darwin", "amd64").selectFile(gupd, "")
+	require.NoError(t, err)
+	require.Equal(t, "Keys-0.0.18-mac.zip", f.URL)
+	f, err = newGithubSource("keys-pub/app", "darwin", "arm64").selectFile(gupd, "")
+	require.NoError(t, err)
+	require.Equal(t, "Keys-0.0.18-arm64-mac.zip", f.URL)
+	f, err = newGithubSource("keys-pub/app", "darwin", "arm64").selectFile(gupd, "dmg")
+	require.NoError(t, err)
+	require.Equal(t, "Keys-0.0.18-arm64.dmg", f.URL)
+	_, err = newGithubSource("keys-pub/app", "darwin", "arm64").selectFile(gupd, "pkg")
+	require.EqualError(t, err, "No pkg file for arm64")
+
+	gupd = update{
+		Version: "0.0.18",
+		Path:    "Keys-0.0.18.AppImage",
+		Files: []file{
+			{URL: "Keys-0.0.18.AppImage"},
+			{URL: "Keys-0.0.18-arm64.AppImage"},
+			{URL: "keys_0.0.18_amd64.deb"},
+			{URL: "keys_0.0.18_arm64.deb"},
+		},
+	}
+	f, err = newGithubSource("keys-pub/app", "linux", "amd64").selectFile(gupd, "deb")
+	require.NoError(t, err)
+	require.Equal(t, "keys_0.0.18_amd64.deb", f.URL)
+	f, err = newGithubSource("keys-pub/app", "linux", "arm64").selectFile(gupd, "")
+	require.NoError(t, err)
+	require.Equal(t, "Keys-0.0.18-arm64.AppImage", f.URL)
+
+	// Files without an arch are amd64
+	_, err = newGithubSource("keys-pub/app", "linux", "386").selectFile(gupd, "")
+	require.EqualError(t, err, "No AppImage file for 386")
+	_, err = newGithubSource("keys-pub/app", "linux", "arm64").selectFile(gupd, "zip")
+	require.EqualError(t, err, "No zip file for arm64")
+
+	// Universal
+	gupd = update{
+		Version: "0.0.18",
+		Files: []file{
+			{URL: "Keys-0.0.18-universal-mac.zip"},
+			{URL: "Keys-0.0.18-mac.zip"},
+		},
+	}
+	f, err = newGithubSource("keys-pub/app", "darwin", "arm64").selectFile(gupd, "")
+	require.NoError(t, err)
+	require.Equal(t, "Keys-0.0.18-universal-mac.zip", f.URL)
+	f, err = newGithubSource("keys-pub/app", "darwin", "amd64").selectFile(gupd, "")
+	require.NoError(t, err)
+	require.Equal(t, "Keys-0.0.18-mac.zip", f.URL)
+
+	// No files, checks path
+	f, err = newGithubSource("keys-pub/app", "darwin", "amd64").selectFile(update{Path: "Keys.zip", SHA512: "abc"}, "")
+	require.NoError(t, err)
+	require.Equal(t, file{URL: "Keys.zip", SHA512: "abc"}, f)
+	_, err = newGithubSource("keys-pub/app", "darwin", "arm64").selectFile(update{Path: "Keys.zip", SHA512: "abc"}, "")
+	require.EqualError(t, err, "No zip file for arm64")
+	_, err = newGithubSource("keys-pub/app", "windows", "amd64").selectFile(update{Path: "Keys.zip", SHA512: "abc"}, "")
+	require.EqualError(t, err, "No msi file for amd64")
+
+	// Windows (msiexec can't install an NSIS exe)
+	gupd = update{
+		Version: "0.0.18",
+		Path:    "Keys-Setup-0.0.18.exe",
+		Files: []file{
+			{URL: "Keys-Setup-0.0.18.exe"},
+			{URL: "Keys-0.0.18.msi"},
+		},
+	}
+	f, err = newGithubSource("keys-pub/app", "windows", "amd64").selectFile(gupd, "")
+	require.NoError(t, err)
+	require.Equal(t, "Keys-0.0.18.msi", f.URL)
+	f, err = newGithubSource("keys-pub/app", "windows", "amd64").selectFile(gupd, "exe")
+	require.NoError(t, err)
+	require.Equal(t, "Keys-Setup-0.0.18.exe", f.URL)
+	_, err = newGithubSource("keys-pub/app", "windows", "amd64").selectFile(update{Path: "Keys-Setup-0.0.18.exe"}, "")
+	require.EqualError(t, err, "No msi file for amd64")
+}
+
+func TestUpdateFormat(t *testing.T) {
+	s := newGithubSource("keys-pub/app", "darwin", "amd64")
+	b, err := ioutil.ReadFile("./testdata/latest-mac.yml")
+	require.NoError(t, err)
+	upd, err := s.updateFromGithub(b, updater.UpdateOptions{Version: "0.0.17", Format: "dmg"})
+	require.NoError(t, err)
+	require.Equal(t, "Keys-0.0.18.dmg", upd.Asset.Name)
+	require.Equal(t, int64(90785677), upd.Asset.Size)
+	require.Equal(t, "8db348468786f4a2afabb11d46b7f97efa54dd08b0dfcddb59d84a2592649f8aad12f4c69ee654751b517ac816bbc3418963d2bee4aabfa7dc530cfb31a83d4f", upd.Asset.Digest)
+	require.Nil(t, upd.Asset.Differential)
 }
 
 func TestPrerelease(t *testing.T) {
 	// SetLogger(NewLogger(DebugLevel))
-	s := newGithubSource("keys-pub/app", "darwin")
-	urs, err := s.findManifestURL(true, time.Second*10)
+	s := newGithubSource("keys-pub/app", "darwin", "amd64")
+	urs, err := s.findManifestURL(context.TODO(), updater.UpdateOptions{Channel: updater.Beta}, time.Second*10)
 	require.NoError(t, err)
 	t.Logf("Prelease: %s", urs)
 
-	urs2, err := s.findManifestURL(false, time.Second*10)
+	urs2, err := s.findManifestURL(context.TODO(), updater.UpdateOptions{Channel: updater.Stable}, time.Second*10)
 	require.NoError(t, err)
 	t.Logf("Latest: %s", urs2)
 	require.True(t, strings.HasPrefix(urs2, "https://github.com/keys-pub/app/releases/latest/download/latest"))
 }
+
+func TestBestRelease(t *testing.T) {
+	rels := []*release{
+		{Tag: "v0.0.20-nightly.1", Prerelease: true},
+		{Tag: "v0.0.20", Draft: true},
+		{Tag: "v0.0.19-alpha.1", Prerelease: true},
+		{Tag: "v0.0.19-beta.2", Prerelease: true},
+		{Tag: "v0.0.19", Prerelease: true},
+		{Tag: "v0.0.18"},
+		{Tag: "invalid"},
+		{Tag: "v0.0.17"},
+	}
+	require.Equal(t, "v0.0.18", bestRelease(rels, updater.UpdateOptions{Channel: updater.Stable}).Tag)
+	require.Equal(t, "v0.0.19", bestRelease(rels, updater.UpdateOptions{Channel: updater.Beta}).Tag)
+	require.Equal(t, "v0.0.19", bestRelease(rels, updater.UpdateOptions{Channel: updater.Alpha}).Tag)
+	require.Equal(t, "v0.0.20-nightly.1", bestRelease(rels, updater.UpdateOptions{Channel: updater.Nightly}).Tag)
+	require.Nil(t, bestRelease([]*release{}, updater.UpdateOptions{Channel: updater.Beta}))
+	require.Nil(t, bestRelease(rels[:2], updater.UpdateOptions{Channel: updater.Beta}))
+
+	policy := &updater.VersionPolicy{Skip: []string{"0.0.19"}}
+	require.Equal(t, "v0.0.19-beta.2", bestRelease(rels, updater.UpdateOptions{Channel: updater.Beta, Policy: policy}).Tag)
+}
+
+func TestVerifyManifest(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	otherPublicKey, otherPrivateKey, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	b, err := ioutil.ReadFile("./testdata/latest-mac.yml")
+	require.NoError(t, err)
+	sig, err := updater.Ed25519Sign(privateKey, bytes.NewReader(b))
+	require.NoError(t, err)
+
+	s := newGithubSource("keys-pub/app", "darwin", "amd64", otherPublicKey, publicKey)
+	err = s.verifyManifest(b, []byte(sig))
+	require.NoError(t, err)
+
+	// Modified manifest
+	b2 := bytes.Replace(b, []byte("version: 0.0.18"), []byte("version: 0.0.19"), 1)
+	err = s.verifyManifest(b2, []byte(sig))
+	require.EqualError(t, err, "Failed to verify manifest: Invalid signature")
+
+	// Signed with key we don't trust
+	s = newGithubSource("keys-pub/app", "darwin", "amd64", otherPublicKey)
+	err = s.verifyManifest(b, []byte(sig))
+	require.EqualError(t, err, fmt.Sprintf("Failed to verify manifest: Unknown signature key (%x)", updater.Ed25519KeyID(publicKey)))
+
+	sig2, err := updater.Ed25519Sign(otherPrivateKey, bytes.NewReader(b))
+	require.NoError(t, err)
+	err = s.verifyManifest(b, []byte(sig2))
+	require.NoError(t, err)
+}
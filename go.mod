@@ -4,7 +4,9 @@ go 1.13
 
 require (
 	github.com/blang/semver v3.5.1+incompatible
+	github.com/klauspost/compress v1.11.13
 	github.com/pkg/errors v0.9.1
 	github.com/stretchr/testify v1.5.1
+	github.com/ulikunitz/xz v0.5.11
 	gopkg.in/yaml.v2 v2.2.2
 )
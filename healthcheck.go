@@ -0,0 +1,88 @@
+package updater
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/blang/semver"
+	"github.com/pkg/errors"
+)
+
+// VersionCheck is a health check (see Installer) that runs the installed
+// executable and checks that it outputs the expected version.
+type VersionCheck struct {
+	// Version is the expected version (usually update.Version).
+	Version string `json:"version"`
+	// Executable is the path to run (relative to the install path), if the
+	// install is a directory, for example, "Contents/MacOS/Keys".
+	// If empty, the install path is run.
+	Executable string `json:"executable,omitempty"`
+	// Args for the executable, defaults to "--version".
+	Args []string `json:"args,omitempty"`
+	// Timeout defaults to 10 seconds.
+	Timeout time.Duration `json:"timeout,omitempty"`
+}
+
+// Check runs the executable for the install at path.
+func (c VersionCheck) Check(path string) error {
+	if c.Version == "" {
+		return errors.Errorf("No version to check")
+	}
+	executable := path
+	if c.Executable != "" {
+		executable = filepath.Join(path, filepath.FromSlash(c.Executable))
+	}
+	fileInfo, err := os.Stat(executable)
+	if err != nil {
+		return err
+	}
+	if fileInfo.IsDir() {
+		return errors.Errorf("Executable is a directory: %s", executable)
+	}
+
+	args := c.Args
+	if len(args) == 0 {
+		args = []string{"--version"}
+	}
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	logger.Infof("Running %s %s", executable, strings.Join(args, " "))
+	out, err := exec.CommandContext(ctx, executable, args...).Output()
+	if ctx.Err() == context.DeadlineExceeded {
+		return errors.Errorf("Command timed out (%s)", timeout)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "Command failed")
+	}
+	if !outputHasVersion(string(out), c.Version) {
+		return errors.Errorf("Version mismatch, expected %s, got %q", c.Version, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// outputHasVersion returns true if output has version, for example,
+// "Keys 1.2.3" or "v1.2.3".
+func outputHasVersion(output string, version string) bool {
+	expected, verr := semver.Make(version)
+	for _, field := range strings.Fields(output) {
+		if field == version {
+			return true
+		}
+		if verr != nil {
+			continue
+		}
+		if v, err := semver.Make(strings.TrimPrefix(field, "v")); err == nil && v.Equals(expected) {
+			return true
+		}
+	}
+	return false
+}
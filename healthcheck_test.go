@@ -0,0 +1,61 @@
+package updater
+
+import (
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testExecutable(t *testing.T) string {
+	switch runtime.GOOS {
+	case "darwin":
+		return "test/test.darwin"
+	case "linux":
+		return "test/test.linux"
+	case "windows":
+		return "test/test.exe"
+	default:
+		t.Skip("Unsupported platform")
+		return ""
+	}
+}
+
+func TestVersionCheck(t *testing.T) {
+	path, err := filepath.Abs(testExecutable(t))
+	require.NoError(t, err)
+
+	err = VersionCheck{Version: "1.2.3-400+cafebeef", Args: []string{"version"}}.Check(path)
+	require.NoError(t, err)
+
+	err = VersionCheck{Version: "1.2.3-400", Args: []string{"version"}}.Check(path)
+	require.NoError(t, err)
+
+	err = VersionCheck{Version: "1.2.4", Args: []string{"version"}}.Check(path)
+	require.EqualError(t, err, `Version mismatch, expected 1.2.4, got "1.2.3-400+cafebeef"`)
+
+	err = VersionCheck{Version: "1.2.3", Args: []string{"err"}}.Check(path)
+	require.EqualError(t, err, "Command failed: exit status 1")
+
+	err = VersionCheck{Version: "1.2.3", Args: []string{"sleep"}, Timeout: 100 * time.Millisecond}.Check(path)
+	require.EqualError(t, err, "Command timed out (100ms)")
+
+	// Executable in install directory
+	dir, file := filepath.Split(path)
+	err = VersionCheck{Version: "1.2.3-400+cafebeef", Executable: file, Args: []string{"version"}}.Check(dir)
+	require.NoError(t, err)
+
+	err = VersionCheck{Version: "1.2.3"}.Check(dir)
+	require.Error(t, err)
+}
+
+func TestOutputHasVersion(t *testing.T) {
+	require.True(t, outputHasVersion("1.2.3\n", "1.2.3"))
+	require.True(t, outputHasVersion("Keys v1.2.3", "1.2.3"))
+	require.True(t, outputHasVersion("Keys 1.2.3+abc", "1.2.3"))
+	require.False(t, outputHasVersion("Keys 1.2.30", "1.2.3"))
+	require.False(t, outputHasVersion("", "1.2.3"))
+	require.True(t, outputHasVersion("build 20200101", "20200101"))
+}
@@ -0,0 +1,142 @@
+package httpjson
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/keys-pub/updater"
+	"github.com/keys-pub/updater/util"
+	"github.com/pkg/errors"
+)
+
+type httpJSONSource struct {
+	urlTemplate string
+	platform    string
+	arch        string
+}
+
+// NewUpdateSource returns an update source that requests JSON (an
+// updater.Update) from a URL template.
+//
+// The template can include {appName}, {platform}, {arch} and {channel}, for
+// example:
+//
+//   https://example.com/{appName}/{channel}/update-{platform}-{arch}.json
+//
+// A relative asset URL is resolved against the update URL.
+func NewUpdateSource(urlTemplate string, platform string, arch string) updater.UpdateSource {
+	return newHTTPJSONSource(urlTemplate, platform, arch)
+}
+
+func newHTTPJSONSource(urlTemplate string, platform string, arch string) httpJSONSource {
+	return httpJSONSource{urlTemplate: urlTemplate, platform: platform, arch: arch}
+}
+
+func (s httpJSONSource) Description() string {
+	return s.urlTemplate
+}
+
+func (s httpJSONSource) FindUpdate(ctx context.Context, options updater.UpdateOptions) (*updater.Update, error) {
+	return s.findUpdate(ctx, options, time.Minute)
+}
+
+func (s httpJSONSource) updateURL(options updater.UpdateOptions) string {
+	r := strings.NewReplacer(
+		"{appName}", url.PathEscape(options.AppName),
+		"{platform}", url.PathEscape(s.platform),
+		"{arch}", url.PathEscape(s.arch),
+		"{channel}", url.PathEscape(string(options.ReleaseChannel())),
+	)
+	return r.Replace(s.urlTemplate)
+}
+
+func (s httpJSONSource) findUpdate(ctx context.Context, options updater.UpdateOptions, timeout time.Duration) (*updater.Update, error) {
+	if s.urlTemplate == "" {
+		return nil, errors.Errorf("No URL specified")
+	}
+
+	urs := s.updateURL(options)
+	logger.Infof("Requesting %s", urs)
+	b, err := request(ctx, urs, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	uu, err := s.updateFromJSON(b, urs, options)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Debugf("Received update response: %#v", uu)
+	return uu, nil
+}
+
+func (s httpJSONSource) updateFromJSON(b []byte, urs string, options updater.UpdateOptions) (*updater.Update, error) {
+	var uu updater.Update
+	if err := json.Unmarshal(b, &uu); err != nil {
+		return nil, errors.Wrapf(err, "Invalid update response")
+	}
+
+	needUpdate, err := updater.NeedUpdate(options, uu.Version)
+	if err != nil {
+		return nil, err
+	}
+	uu.NeedUpdate = needUpdate
+
+	if uu.Asset != nil {
+		if err := updater.CheckAssetName(uu.Asset.Name); err != nil {
+			return nil, err
+		}
+		// LocalPath is only set by the updater (when downloaded)
+		uu.Asset.LocalPath = ""
+		if uu.Asset.URL != "" {
+			assetURL, err := resolveURL(urs, uu.Asset.URL)
+			if err != nil {
+				return nil, err
+			}
+			uu.Asset.URL = assetURL
+		}
+	}
+	uu.Applied = ""
+
+	return &uu, nil
+}
+
+// resolveURL resolves a (possibly relative) URL against a base URL.
+func resolveURL(base string, ref string) (string, error) {
+	b, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	r, err := url.Parse(ref)
+	if err != nil {
+		return "", err
+	}
+	return b.ResolveReference(r).String(), nil
+}
+
+func request(ctx context.Context, urs string, timeout time.Duration) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", urs, nil)
+	if err != nil {
+		return nil, err
+	}
+	client := util.HTTPClient(timeout)
+
+	resp, err := client.Do(req)
+	defer util.DiscardAndCloseBodyIgnoreError(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Find update returned bad HTTP status %v", resp.Status)
+	}
+
+	return ioutil.ReadAll(resp.Body)
+}
@@ -0,0 +1,79 @@
+package httpjson
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/keys-pub/updater"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdateURL(t *testing.T) {
+	s := newHTTPJSONSource("https://example.com/{appName}/{channel}/update-{platform}-{arch}.json", "darwin", "amd64")
+	urs := s.updateURL(updater.UpdateOptions{AppName: "Keys"})
+	require.Equal(t, "https://example.com/Keys/stable/update-darwin-amd64.json", urs)
+
+	urs = s.updateURL(updater.UpdateOptions{AppName: "Keys", Prerelease: true})
+	require.Equal(t, "https://example.com/Keys/beta/update-darwin-amd64.json", urs)
+}
+
+func TestUpdate(t *testing.T) {
+	s := newHTTPJSONSource("https://example.com/{appName}/update.json", "darwin", "amd64")
+	b, err := ioutil.ReadFile("../test/update.json")
+	require.NoError(t, err)
+
+	upd, err := s.updateFromJSON(b, "https://example.com/Keys/update.json", updater.UpdateOptions{Version: "1.2.2", Prerelease: true})
+	require.NoError(t, err)
+	require.Equal(t, "1.2.3-400+abcdef", upd.Version)
+	require.Equal(t, int64(1460660414000), upd.PublishedAt)
+	require.NotNil(t, upd.Asset)
+	require.Equal(t, "https://example.com/Keys/test.zip", upd.Asset.URL)
+	require.Equal(t, "3a147f31b25a6027bda15367def6f4499e29a9b531855c0ac881a8f3a83a12b9", upd.Asset.Digest)
+	require.NotEmpty(t, upd.Asset.Signature)
+	require.True(t, upd.NeedUpdate)
+
+	upd, err = s.updateFromJSON(b, "https://example.com/Keys/update.json", updater.UpdateOptions{Version: "1.2.3", Prerelease: true})
+	require.NoError(t, err)
+	require.False(t, upd.NeedUpdate)
+
+	// Prerelease (beta) isn't in the stable channel
+	upd, err = s.updateFromJSON(b, "https://example.com/Keys/update.json", updater.UpdateOptions{Version: "1.2.2"})
+	require.NoError(t, err)
+	require.False(t, upd.NeedUpdate)
+
+	_, err = s.updateFromJSON(b, "https://example.com/Keys/update.json", updater.UpdateOptions{Version: "invalid"})
+	require.EqualError(t, err, `Invalid current version "invalid": No Major.Minor.Patch elements found`)
+}
+
+func TestUpdateInvalidAssetName(t *testing.T) {
+	s := newHTTPJSONSource("https://example.com/{appName}/update.json", "darwin", "amd64")
+	for _, name := range []string{"../../.bashrc", "dir/test.zip", "..", ""} {
+		b := []byte(fmt.Sprintf(`{"version": "1.2.3", "asset": {"name": %q, "url": "test.zip", "digest": "abc"}}`, name))
+		_, err := s.updateFromJSON(b, "https://example.com/Keys/update.json", updater.UpdateOptions{Version: "1.2.2"})
+		require.EqualError(t, err, fmt.Sprintf("Invalid asset name %q", name))
+	}
+}
+
+func TestFindUpdate(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/Keys/linux/update.json" {
+			http.Error(w, "Not Found", 404)
+			return
+		}
+		http.ServeFile(w, r, "../test/update.json")
+	}))
+	defer server.Close()
+
+	s := NewUpdateSource(server.URL+"/{appName}/{platform}/update.json", "linux", "amd64")
+	upd, err := s.FindUpdate(context.TODO(), updater.UpdateOptions{AppName: "Keys", Version: "1.0.0", Prerelease: true})
+	require.NoError(t, err)
+	require.True(t, upd.NeedUpdate)
+	require.Equal(t, server.URL+"/Keys/linux/test.zip", upd.Asset.URL)
+
+	_, err = s.FindUpdate(context.TODO(), updater.UpdateOptions{AppName: "Other", Version: "1.0.0"})
+	require.EqualError(t, err, "Find update returned bad HTTP status 404 Not Found")
+}
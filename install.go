@@ -0,0 +1,253 @@
+package updater
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/keys-pub/updater/util"
+	"github.com/pkg/errors"
+)
+
+// Installer moves a staged install into place as a transaction.
+//
+// The existing install is backed up (to the install path with a ".old"
+// extension), the staged install is moved into place, and the health check
+// (if any) is run. If any step fails, the backup is restored, otherwise it's
+// removed.
+//
+// Each step is recorded in a journal, so if we are interrupted, the next
+// Recover can finish or roll back the install.
+type Installer struct {
+	journalPath string
+
+	// HealthCheck (optional) is run after the staged install is moved into
+	// place. If it fails the install is rolled back.
+	HealthCheck func(path string) error
+	// VersionCheck (optional) is run like HealthCheck, and is saved in the
+	// journal, so it's also run if we Recover an interrupted install (in a
+	// new process, before HealthCheck is set).
+	VersionCheck *VersionCheck
+}
+
+// installState is the step of an install in the journal.
+type installState string
+
+const (
+	installStaged   installState = "staged"
+	installBackedUp installState = "backedUp"
+	installSwapped  installState = "swapped"
+)
+
+// installJournal is saved (atomically) for each step of an install.
+type installJournal struct {
+	State      installState  `json:"state"`
+	Path       string        `json:"path"`
+	StagePath  string        `json:"stagePath"`
+	BackupPath string        `json:"backupPath,omitempty"`
+	Check      *VersionCheck `json:"check,omitempty"`
+}
+
+// InstallError is returned if an install failed.
+type InstallError struct {
+	Err error
+	// RolledBack is true if the previous install was restored.
+	RolledBack bool
+}
+
+func (e InstallError) Error() string {
+	if e.RolledBack {
+		return "Install failed (rolled back): " + e.Err.Error()
+	}
+	return "Install failed: " + e.Err.Error()
+}
+
+// Cause returns the underlying error (for errors.Cause).
+func (e InstallError) Cause() error {
+	return e.Err
+}
+
+// NewInstaller creates an Installer with the journal in dir, see DataDir.
+func NewInstaller(dir string) *Installer {
+	return &Installer{journalPath: filepath.Join(dir, "install.json")}
+}
+
+func (i *Installer) save(j *installJournal) error {
+	if err := util.MakeParentDirs(i.journalPath, 0700); err != nil {
+		return err
+	}
+	b, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	return util.NewFile(i.journalPath, b, 0600).Save()
+}
+
+func (i *Installer) load() (*installJournal, error) {
+	b, err := ioutil.ReadFile(i.journalPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var j installJournal
+	if err := json.Unmarshal(b, &j); err != nil {
+		return nil, errors.Wrapf(err, "Invalid install journal")
+	}
+	return &j, nil
+}
+
+func (i *Installer) finish() error {
+	if err := os.Remove(i.journalPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Install moves stagePath to path.
+// If there is an existing install at path, it is kept at path with a ".old"
+// extension until the install succeeds, and is restored if the install fails.
+// If the install failed and was rolled back, returns an InstallError.
+func (i *Installer) Install(stagePath string, path string) error {
+	if err := i.Recover(); err != nil {
+		// If a previous install failed (and was rolled back), we can continue
+		if ierr, ok := err.(InstallError); !ok || !ierr.RolledBack {
+			return err
+		}
+		logger.Warningf("Previous install was rolled back: %v", err)
+	}
+
+	exists, err := util.FileExists(path)
+	if err != nil {
+		return err
+	}
+	j := &installJournal{State: installStaged, Path: path, StagePath: stagePath, Check: i.VersionCheck}
+	if exists {
+		j.BackupPath = path + ".old"
+		util.RemoveFileAtPath(j.BackupPath)
+	}
+	// Save the backup path before we move anything, so we can restore it
+	if err := i.save(j); err != nil {
+		return err
+	}
+
+	if exists {
+		logger.Infof("Moving existing %s to %s", path, j.BackupPath)
+		if err := os.Rename(path, j.BackupPath); err != nil {
+			return i.fail(j, err)
+		}
+	}
+	j.State = installBackedUp
+	if err := i.save(j); err != nil {
+		return i.fail(j, err)
+	}
+
+	logger.Infof("Moving %s to %s", stagePath, path)
+	if err := os.Rename(stagePath, path); err != nil {
+		return i.fail(j, err)
+	}
+	j.State = installSwapped
+	if err := i.save(j); err != nil {
+		return i.fail(j, err)
+	}
+
+	return i.check(j)
+}
+
+// check runs the health checks for a swapped install, and finishes or rolls
+// back.
+func (i *Installer) check(j *installJournal) error {
+	if j.Check != nil {
+		logger.Infof("Checking %s (version %s)", j.Path, j.Check.Version)
+		if err := j.Check.Check(j.Path); err != nil {
+			return i.fail(j, errors.Wrapf(err, "Health check failed"))
+		}
+	}
+	if i.HealthCheck != nil {
+		logger.Infof("Checking %s", j.Path)
+		if err := i.HealthCheck(j.Path); err != nil {
+			return i.fail(j, errors.Wrapf(err, "Health check failed"))
+		}
+	}
+	if err := i.finish(); err != nil {
+		return err
+	}
+	// Remove the backup after the journal, so if we are interrupted we can
+	// still roll back
+	if j.BackupPath != "" {
+		logger.Infof("Removing %s", j.BackupPath)
+		util.RemoveFileAtPath(j.BackupPath)
+	}
+	return nil
+}
+
+// fail rolls back an install and returns an InstallError.
+func (i *Installer) fail(j *installJournal, err error) error {
+	logger.Errorf("Install failed: %v", err)
+	if rerr := i.rollback(j); rerr != nil {
+		return InstallError{Err: util.CombineErrors(err, rerr)}
+	}
+	return InstallError{Err: err, RolledBack: true}
+}
+
+// rollback restores the backup (if any) and removes the journal.
+func (i *Installer) rollback(j *installJournal) error {
+	if j.State == installSwapped {
+		// Move the new install back to the stage path (so it's removed below)
+		logger.Infof("Moving %s to %s", j.Path, j.StagePath)
+		util.RemoveFileAtPath(j.StagePath)
+		if err := os.Rename(j.Path, j.StagePath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if j.BackupPath != "" {
+		if exists, err := util.FileExists(j.BackupPath); err != nil {
+			return err
+		} else if exists {
+			logger.Infof("Restoring %s from %s", j.Path, j.BackupPath)
+			util.RemoveFileAtPath(j.Path)
+			if err := os.Rename(j.BackupPath, j.Path); err != nil {
+				return err
+			}
+		}
+	}
+	util.RemoveFileAtPath(j.StagePath)
+	return i.finish()
+}
+
+// Recover finishes or rolls back an install that was interrupted.
+// If the staged install was moved into place, we finish the install (running
+// the health checks, including the VersionCheck saved in the journal),
+// otherwise the install is rolled back.
+func (i *Installer) Recover() error {
+	j, err := i.load()
+	if err != nil {
+		return err
+	}
+	if j == nil {
+		return nil
+	}
+	logger.Infof("Recovering install (%s) of %s", j.State, j.Path)
+	if j.State == installBackedUp {
+		// If we were interrupted after the staged install was moved into place
+		stageExists, err := util.FileExists(j.StagePath)
+		if err != nil {
+			return err
+		}
+		pathExists, err := util.FileExists(j.Path)
+		if err != nil {
+			return err
+		}
+		if !stageExists && pathExists {
+			j.State = installSwapped
+		}
+	}
+	switch j.State {
+	case installSwapped:
+		return i.check(j)
+	default:
+		return i.rollback(j)
+	}
+}
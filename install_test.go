@@ -0,0 +1,170 @@
+package updater
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/keys-pub/updater/util"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func testInstallDir(t *testing.T) (string, string, string) {
+	dir, err := util.MakeTempDir("TestInstall.", 0700)
+	require.NoError(t, err)
+	path := filepath.Join(dir, "Test.app")
+	err = ioutil.WriteFile(path, []byte("old"), 0600)
+	require.NoError(t, err)
+	stagePath := filepath.Join(dir, "Test.app.new")
+	err = ioutil.WriteFile(stagePath, []byte("new"), 0600)
+	require.NoError(t, err)
+	return dir, path, stagePath
+}
+
+func requireFile(t *testing.T, path string, expected string) {
+	b, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, expected, string(b))
+}
+
+func requireNotExists(t *testing.T, path string) {
+	exists, err := util.FileExists(path)
+	require.NoError(t, err)
+	require.False(t, exists, "%s exists", path)
+}
+
+func TestInstall(t *testing.T) {
+	dir, path, stagePath := testInstallDir(t)
+	defer util.RemoveFileAtPath(dir)
+
+	installer := NewInstaller(dir)
+	checked := ""
+	installer.HealthCheck = func(path string) error {
+		checked = path
+		return nil
+	}
+	err := installer.Install(stagePath, path)
+	require.NoError(t, err)
+	require.Equal(t, path, checked)
+
+	requireFile(t, path, "new")
+	requireNotExists(t, path+".old")
+	requireNotExists(t, stagePath)
+	requireNotExists(t, filepath.Join(dir, "install.json"))
+}
+
+func TestInstallRollback(t *testing.T) {
+	dir, path, stagePath := testInstallDir(t)
+	defer util.RemoveFileAtPath(dir)
+
+	installer := NewInstaller(dir)
+	installer.HealthCheck = func(path string) error {
+		return errors.Errorf("Crashed")
+	}
+	err := installer.Install(stagePath, path)
+	require.EqualError(t, err, "Install failed (rolled back): Health check failed: Crashed")
+	ierr, ok := err.(InstallError)
+	require.True(t, ok)
+	require.True(t, ierr.RolledBack)
+
+	requireFile(t, path, "old")
+	requireNotExists(t, path+".old")
+	requireNotExists(t, stagePath)
+	requireNotExists(t, filepath.Join(dir, "install.json"))
+
+	// Fresh install
+	util.RemoveFileAtPath(path)
+	err = ioutil.WriteFile(stagePath, []byte("new"), 0600)
+	require.NoError(t, err)
+	err = installer.Install(stagePath, path)
+	require.Error(t, err)
+	requireNotExists(t, path)
+	requireNotExists(t, stagePath)
+}
+
+func TestInstallRecover(t *testing.T) {
+	dir, path, stagePath := testInstallDir(t)
+	defer util.RemoveFileAtPath(dir)
+	installer := NewInstaller(dir)
+
+	// Interrupted after backup, before swap
+	err := os.Rename(path, path+".old")
+	require.NoError(t, err)
+	err = installer.save(&installJournal{State: installBackedUp, Path: path, StagePath: stagePath, BackupPath: path + ".old"})
+	require.NoError(t, err)
+	err = installer.Recover()
+	require.NoError(t, err)
+	requireFile(t, path, "old")
+	requireNotExists(t, stagePath)
+	requireNotExists(t, filepath.Join(dir, "install.json"))
+
+	// Interrupted after swap (before health check)
+	err = os.Rename(path, path+".old")
+	require.NoError(t, err)
+	err = ioutil.WriteFile(path, []byte("new"), 0600)
+	require.NoError(t, err)
+	err = installer.save(&installJournal{State: installSwapped, Path: path, StagePath: stagePath, BackupPath: path + ".old"})
+	require.NoError(t, err)
+	installer.HealthCheck = func(path string) error { return errors.Errorf("Crashed") }
+	err = installer.Recover()
+	require.EqualError(t, err, "Install failed (rolled back): Health check failed: Crashed")
+	requireFile(t, path, "old")
+
+	// Interrupted after swap (before journal was updated)
+	err = os.Rename(path, path+".old")
+	require.NoError(t, err)
+	err = ioutil.WriteFile(path, []byte("new"), 0600)
+	require.NoError(t, err)
+	err = installer.save(&installJournal{State: installBackedUp, Path: path, StagePath: stagePath, BackupPath: path + ".old"})
+	require.NoError(t, err)
+	installer.HealthCheck = nil
+	err = installer.Recover()
+	require.NoError(t, err)
+	requireFile(t, path, "new")
+	requireNotExists(t, path+".old")
+	requireNotExists(t, filepath.Join(dir, "install.json"))
+}
+
+func TestInstallVersionCheck(t *testing.T) {
+	dir, path, stagePath := testInstallDir(t)
+	defer util.RemoveFileAtPath(dir)
+
+	installer := NewInstaller(dir)
+	// The install isn't executable, so the check fails
+	installer.VersionCheck = &VersionCheck{Version: "1.2.4", Args: []string{"version"}}
+	err := installer.Install(stagePath, path)
+	require.Error(t, err)
+	ierr, ok := err.(InstallError)
+	require.True(t, ok)
+	require.True(t, ierr.RolledBack)
+	requireFile(t, path, "old")
+	requireNotExists(t, filepath.Join(dir, "install.json"))
+}
+
+func TestInstallRecoverVersionCheck(t *testing.T) {
+	dir, path, stagePath := testInstallDir(t)
+	defer util.RemoveFileAtPath(dir)
+
+	// Interrupted after swap (before health check), in a previous process
+	err := os.Rename(path, path+".old")
+	require.NoError(t, err)
+	err = ioutil.WriteFile(path, []byte("new"), 0600)
+	require.NoError(t, err)
+	check := &VersionCheck{Version: "1.2.4", Args: []string{"version"}}
+	err = NewInstaller(dir).save(&installJournal{State: installSwapped, Path: path, StagePath: stagePath, BackupPath: path + ".old", Check: check})
+	require.NoError(t, err)
+
+	// Recover (without HealthCheck set) runs the saved check, which fails
+	installer := NewInstaller(dir)
+	err = installer.Recover()
+	require.Error(t, err)
+	ierr, ok := err.(InstallError)
+	require.True(t, ok)
+	require.True(t, ierr.RolledBack)
+	requireFile(t, path, "old")
+	requireNotExists(t, path+".old")
+	requireNotExists(t, stagePath)
+	requireNotExists(t, filepath.Join(dir, "install.json"))
+}
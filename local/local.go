@@ -0,0 +1,267 @@
+package local
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/blang/semver"
+	"github.com/keys-pub/updater"
+	"github.com/keys-pub/updater/util"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+type localSource struct {
+	dir      string
+	platform string
+}
+
+// electron-builder manifest (latest-mac.yml, latest-linux.yml, etc).
+type ymlUpdate struct {
+	Version     string `yaml:"version"`
+	Path        string `yaml:"path"`
+	SHA512      string `yaml:"sha512"`
+	ReleaseDate string `yaml:"releaseDate"`
+	// StagingPercentage is the percentage of installs to roll out to.
+	StagingPercentage *int `yaml:"stagingPercentage"`
+	Files             []struct {
+		URL  string `yaml:"url"`
+		Size int64  `yaml:"size"`
+	} `yaml:"files"`
+}
+
+// NewUpdateSource returns an update source for a local directory, for
+// example, releases copied from a USB drive.
+//
+// The directory (and its subdirectories, one level deep) is scanned for
+// electron-builder manifests (latest-mac.yml, latest-linux.yml,
+// latest-windows.yml) or update JSON manifests (update.json or
+// update-{platform}.json), and the newest version is returned.
+// The update asset has a file:// URL.
+func NewUpdateSource(dir string, platform string) updater.UpdateSource {
+	return newLocalSource(dir, platform)
+}
+
+func newLocalSource(dir string, platform string) localSource {
+	return localSource{dir: dir, platform: platform}
+}
+
+func (s localSource) Description() string {
+	return util.URLStringForPath(s.dir)
+}
+
+func (s localSource) FindUpdate(ctx context.Context, options updater.UpdateOptions) (*updater.Update, error) {
+	if s.dir == "" {
+		return nil, errors.Errorf("No directory specified")
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if _, err := semver.Make(options.Version); err != nil {
+		return nil, errors.Wrapf(err, "Invalid current version %q", options.Version)
+	}
+
+	paths, err := s.manifestPaths()
+	if err != nil {
+		return nil, err
+	}
+
+	var best *updater.Update
+	var bestVersion semver.Version
+	for _, path := range paths {
+		logger.Debugf("Checking %s", path)
+		uu, err := s.updateFromManifest(path)
+		if err != nil {
+			logger.Warningf("Skipping manifest %s: %v", path, err)
+			continue
+		}
+		version, err := semver.Make(uu.Version)
+		if err != nil {
+			logger.Warningf("Skipping manifest %s: invalid version %q", path, uu.Version)
+			continue
+		}
+		if ok, err := options.Allows(version); err != nil {
+			return nil, err
+		} else if !ok {
+			continue
+		}
+		if best == nil || bestVersion.LT(version) {
+			best, bestVersion = uu, version
+		}
+	}
+	if best == nil {
+		return nil, nil
+	}
+
+	needUpdate, err := updater.NeedUpdate(options, best.Version)
+	if err != nil {
+		return nil, err
+	}
+	best.NeedUpdate = needUpdate
+	logger.Debugf("Found update: %#v", best)
+	return best, nil
+}
+
+func (s localSource) ymlManifestName() (string, error) {
+	switch s.platform {
+	case "darwin":
+		return "latest-mac.yml", nil
+	case "windows":
+		return "latest-windows.yml", nil
+	case "linux":
+		return "latest-linux.yml", nil
+	default:
+		return "", errors.Errorf("Unsupported platform")
+	}
+}
+
+func (s localSource) isManifest(name string) bool {
+	ymlName, err := s.ymlManifestName()
+	if err != nil {
+		return false
+	}
+	switch name {
+	case ymlName, "update.json", fmt.Sprintf("update-%s.json", s.platform):
+		return true
+	default:
+		return false
+	}
+}
+
+// manifestPaths returns manifests in dir and its subdirectories (one level).
+func (s localSource) manifestPaths() ([]string, error) {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	paths := []string{}
+	for _, file := range files {
+		path := filepath.Join(s.dir, file.Name())
+		if !file.IsDir() {
+			if s.isManifest(file.Name()) {
+				paths = append(paths, path)
+			}
+			continue
+		}
+		subfiles, err := ioutil.ReadDir(path)
+		if err != nil {
+			logger.Warningf("Error listing %s: %v", path, err)
+			continue
+		}
+		for _, subfile := range subfiles {
+			if !subfile.IsDir() && s.isManifest(subfile.Name()) {
+				paths = append(paths, filepath.Join(path, subfile.Name()))
+			}
+		}
+	}
+	return paths, nil
+}
+
+func (s localSource) updateFromManifest(path string) (*updater.Update, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var uu *updater.Update
+	if filepath.Ext(path) == ".json" {
+		uu, err = updateFromJSON(b, filepath.Dir(path))
+	} else {
+		uu, err = updateFromYML(b, filepath.Dir(path))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if uu.Asset == nil || uu.Asset.URL == "" {
+		return nil, errors.Errorf("No asset")
+	}
+	return uu, nil
+}
+
+func updateFromYML(b []byte, dir string) (*updater.Update, error) {
+	var yupd ymlUpdate
+	if err := yaml.Unmarshal(b, &yupd); err != nil {
+		return nil, err
+	}
+	t, err := time.Parse(time.RFC3339Nano, yupd.ReleaseDate)
+	if err != nil {
+		return nil, err
+	}
+	digest, err := base64.StdEncoding.DecodeString(yupd.SHA512)
+	if err != nil {
+		return nil, err
+	}
+	assetPath, err := localAssetPath(dir, yupd.Path)
+	if err != nil {
+		return nil, err
+	}
+	var size int64
+	for _, f := range yupd.Files {
+		if f.URL == yupd.Path {
+			size = f.Size
+		}
+	}
+	return &updater.Update{
+		Version:     yupd.Version,
+		PublishedAt: int64(util.TimeToMillis(t)),
+		Asset: &updater.Asset{
+			Name:       filepath.Base(yupd.Path),
+			URL:        util.URLStringForPath(assetPath),
+			Digest:     hex.EncodeToString(digest),
+			DigestType: "sha512",
+			Size:       size,
+		},
+		Rollout: yupd.StagingPercentage,
+	}, nil
+}
+
+func updateFromJSON(b []byte, dir string) (*updater.Update, error) {
+	var uu updater.Update
+	if err := json.Unmarshal(b, &uu); err != nil {
+		return nil, err
+	}
+	uu.Applied = ""
+	if uu.Asset != nil {
+		if err := updater.CheckAssetName(uu.Asset.Name); err != nil {
+			return nil, err
+		}
+		uu.Asset.LocalPath = ""
+		// Asset URL (if not a URL) is a path relative to the manifest
+		if u, err := url.Parse(uu.Asset.URL); err != nil || u.Scheme == "" {
+			assetPath, err := localAssetPath(dir, uu.Asset.URL)
+			if err != nil {
+				return nil, err
+			}
+			uu.Asset.URL = util.URLStringForPath(assetPath)
+		}
+	}
+	return &uu, nil
+}
+
+// localAssetPath returns the path to an asset (relative to dir), checking
+// that it exists.
+func localAssetPath(dir string, name string) (string, error) {
+	if name == "" {
+		return "", errors.Errorf("No asset path")
+	}
+	path := name
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, filepath.FromSlash(name))
+	}
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
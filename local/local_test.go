@@ -0,0 +1,127 @@
+package local
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/keys-pub/updater"
+	"github.com/keys-pub/updater/util"
+	"github.com/stretchr/testify/require"
+)
+
+func testDir(t *testing.T) string {
+	dir, err := util.MakeTempDir("TestLocal.", 0700)
+	require.NoError(t, err)
+
+	// electron-builder release
+	err = os.MkdirAll(filepath.Join(dir, "0.0.18"), 0700)
+	require.NoError(t, err)
+	err = util.CopyFile("../github/testdata/latest-mac.yml", filepath.Join(dir, "0.0.18", "latest-mac.yml"))
+	require.NoError(t, err)
+	err = ioutil.WriteFile(filepath.Join(dir, "0.0.18", "Keys-0.0.18-mac.zip"), []byte("test"), 0600)
+	require.NoError(t, err)
+
+	// Update JSON release (prerelease)
+	err = os.MkdirAll(filepath.Join(dir, "prerelease"), 0700)
+	require.NoError(t, err)
+	err = util.CopyFile("../test/update.json", filepath.Join(dir, "prerelease", "update.json"))
+	require.NoError(t, err)
+	err = util.CopyFile("../test/test.zip", filepath.Join(dir, "prerelease", "test.zip"))
+	require.NoError(t, err)
+
+	// Manifest for another platform
+	err = ioutil.WriteFile(filepath.Join(dir, "latest-linux.yml"), []byte("version: 9.9.9"), 0600)
+	require.NoError(t, err)
+	return dir
+}
+
+func TestFindUpdate(t *testing.T) {
+	dir := testDir(t)
+	defer util.RemoveFileAtPath(dir)
+
+	src := NewUpdateSource(dir, "darwin")
+	upd, err := src.FindUpdate(context.TODO(), updater.UpdateOptions{Version: "0.0.17"})
+	require.NoError(t, err)
+	require.NotNil(t, upd)
+	require.Equal(t, "0.0.18", upd.Version)
+	require.True(t, upd.NeedUpdate)
+	require.Equal(t, "Keys-0.0.18-mac.zip", upd.Asset.Name)
+	require.Equal(t, util.URLStringForPath(filepath.Join(dir, "0.0.18", "Keys-0.0.18-mac.zip")), upd.Asset.URL)
+	require.Equal(t, "9fe462603acbd84e55e5dfa6a02f40d0483551c88bd053b4b3827aba67d7fe3e53414a2214f6387a02e0bfc667d464ed0cc494f14b6ca04ae5ca81a20d503618", upd.Asset.Digest)
+	require.Equal(t, int64(87933949), upd.Asset.Size)
+	require.Nil(t, upd.Rollout)
+	require.Equal(t, 100, upd.RolloutPercent())
+
+	upd, err = src.FindUpdate(context.TODO(), updater.UpdateOptions{Version: "0.0.18"})
+	require.NoError(t, err)
+	require.False(t, upd.NeedUpdate)
+
+	upd, err = src.FindUpdate(context.TODO(), updater.UpdateOptions{Version: "0.0.18", Prerelease: true})
+	require.NoError(t, err)
+	require.Equal(t, "1.2.3-400+abcdef", upd.Version)
+	require.True(t, upd.NeedUpdate)
+	require.Equal(t, util.URLStringForPath(filepath.Join(dir, "prerelease", "test.zip")), upd.Asset.URL)
+
+	upd, err = NewUpdateSource(dir, "windows").FindUpdate(context.TODO(), updater.UpdateOptions{Version: "0.0.17"})
+	require.NoError(t, err)
+	require.Nil(t, upd)
+}
+
+func TestDownload(t *testing.T) {
+	dir, err := util.MakeTempDir("TestLocal.", 0700)
+	require.NoError(t, err)
+	defer util.RemoveFileAtPath(dir)
+	err = util.CopyFile("../test/test.zip", filepath.Join(dir, "test.zip"))
+	require.NoError(t, err)
+	update := `{"version": "1.0.1", "asset": {"name": "test.zip", "url": "test.zip", "digest": "54970995e4d02da631e0634162ef66e2663e0eee7d018e816ac48ed6f7811c84"}}`
+	err = ioutil.WriteFile(filepath.Join(dir, "update-linux.json"), []byte(update), 0600)
+	require.NoError(t, err)
+
+	options := updater.UpdateOptions{AppName: "TestLocalDownload", Version: "1.0.0"}
+	upr := updater.NewUpdater(NewUpdateSource(dir, "linux"))
+	upd, err := upr.CheckForUpdate(context.TODO(), options)
+	require.NoError(t, err)
+	require.True(t, upd.NeedUpdate)
+
+	err = upr.Download(context.TODO(), upd, options)
+	require.NoError(t, err)
+	defer util.RemoveFileAtPath(filepath.Dir(upd.Asset.LocalPath))
+	exists, err := util.FileExists(upd.Asset.LocalPath)
+	require.NoError(t, err)
+	require.True(t, exists)
+}
+
+func TestFindUpdateInvalidAssetName(t *testing.T) {
+	dir, err := util.MakeTempDir("TestLocal.", 0700)
+	require.NoError(t, err)
+	defer util.RemoveFileAtPath(dir)
+	err = util.CopyFile("../test/test.zip", filepath.Join(dir, "test.zip"))
+	require.NoError(t, err)
+	update := `{"version": "1.0.1", "asset": {"name": "../../.bashrc", "url": "test.zip", "digest": "54970995e4d02da631e0634162ef66e2663e0eee7d018e816ac48ed6f7811c84"}}`
+	err = ioutil.WriteFile(filepath.Join(dir, "update-linux.json"), []byte(update), 0600)
+	require.NoError(t, err)
+
+	// Manifest is skipped
+	upd, err := NewUpdateSource(dir, "linux").FindUpdate(context.TODO(), updater.UpdateOptions{Version: "1.0.0"})
+	require.NoError(t, err)
+	require.Nil(t, upd)
+}
+
+func TestFindUpdateStagingPercentage(t *testing.T) {
+	dir := testDir(t)
+	defer util.RemoveFileAtPath(dir)
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "0.0.18", "latest-mac.yml"))
+	require.NoError(t, err)
+	b = append(b, []byte("stagingPercentage: 0\n")...)
+	err = ioutil.WriteFile(filepath.Join(dir, "0.0.18", "latest-mac.yml"), b, 0600)
+	require.NoError(t, err)
+
+	upd, err := NewUpdateSource(dir, "darwin").FindUpdate(context.TODO(), updater.UpdateOptions{Version: "0.0.17"})
+	require.NoError(t, err)
+	require.NotNil(t, upd.Rollout)
+	require.Equal(t, 0, upd.RolloutPercent())
+}
@@ -9,12 +9,32 @@ type Asset struct {
 	Name string `json:"name"`
 	// URL to request from.
 	URL string `json:"url"`
+	// Mirrors are URLs to try (in order) if the download from URL fails.
+	Mirrors []string `json:"mirrors,omitempty"`
 	// Digest is hex encoded digest.
 	Digest string `json:"digest"`
 	// DigestType is sha256 by default. Also supports "sha512".
 	DigestType string `json:"digestType"`
+	// Signature is a detached signature for the file, checked by the Updater
+	// Verifier (if set).
+	Signature string `json:"signature,omitempty"`
+	// Size is the expected size (if known).
+	Size int64 `json:"size,omitempty"`
 	// LocalPath is where downloaded file resides.
 	LocalPath string `json:"localPath"`
+	// Differential is set if we can download only the changed blocks (from a
+	// previously downloaded asset).
+	Differential *Differential `json:"differential,omitempty"`
+}
+
+// Differential describes an electron-builder blockmap differential download.
+type Differential struct {
+	// BlockMapURL is the blockmap for the asset.
+	BlockMapURL string `json:"blockMapURL"`
+	// PreviousName is the file name of the asset for the current version.
+	PreviousName string `json:"previousName"`
+	// PreviousBlockMapURL is the blockmap for the asset for the current version.
+	PreviousBlockMapURL string `json:"previousBlockMapURL"`
 }
 
 // Property is a generic key value pair for custom properties
@@ -27,13 +47,20 @@ type Property struct {
 // If update is needed, NeedUpdate will be true.
 // If update is downloaded, Asset.LocalPath will be set.
 // If update was applied, Applied is set to the destination.
+// If update failed to apply, ApplyError is set, and RolledBack is true if the
+// previous install was restored.
+// If the update is being rolled out to a percentage of installs (Rollout), see
+// RolloutPercent.
 type Update struct {
 	Version     string     `json:"version"`
 	PublishedAt int64      `json:"publishedAt"`
 	Props       []Property `codec:"props" json:"props,omitempty"`
 	Asset       *Asset     `json:"asset,omitempty"`
+	Rollout     *int       `json:"rollout,omitempty"`
 	NeedUpdate  bool       `json:"needUpdate"`
 	Applied     string     `json:"applied"`
+	ApplyError  string     `json:"applyError,omitempty"`
+	RolledBack  bool       `json:"rolledBack,omitempty"`
 }
 
 // UpdateOptions are options used to find an update
@@ -42,6 +69,17 @@ type UpdateOptions struct {
 	Version string `json:"version"`
 	// AppName is name of the app
 	AppName string `json:"appName"`
-	// Prerelease will request latest prerelease
+	// Prerelease will request latest prerelease (the beta channel), if Channel
+	// isn't set.
 	Prerelease bool `json:"prerelease"`
+	// Channel is the release channel (stable, beta, alpha, nightly).
+	Channel Channel `json:"channel,omitempty"`
+	// Format is the preferred asset format (file extension, for example, zip,
+	// dmg or AppImage), for sources with multiple files for a release.
+	Format string `json:"format,omitempty"`
+	// Policy is the version policy (DefaultVersionPolicy if not set).
+	Policy *VersionPolicy `json:"policy,omitempty"`
+	// InstallID is a stable ID for the install, for staged rollouts, see
+	// State.EnsureInstallID.
+	InstallID string `json:"installId,omitempty"`
 }
@@ -0,0 +1,61 @@
+package updater
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"strconv"
+	"strings"
+)
+
+// RolloutProperty is the Update.Props name for the rollout percentage, if the
+// update source doesn't set Update.Rollout.
+const RolloutProperty = "rollout"
+
+// RolloutPercent returns the percentage of installs the update is released to
+// (0-100), from Rollout or the rollout property.
+// If not set (or invalid), the update is released to all installs (100).
+// If set to 0, the update isn't released to any installs (yet).
+func (u Update) RolloutPercent() int {
+	percent := 100
+	if u.Rollout != nil {
+		percent = *u.Rollout
+	} else {
+		for _, prop := range u.Props {
+			if prop.Name != RolloutProperty {
+				continue
+			}
+			p, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(prop.Value), "%"))
+			if err != nil {
+				logger.Warningf("Invalid rollout property %q", prop.Value)
+				continue
+			}
+			percent = p
+		}
+	}
+	switch {
+	case percent < 0:
+		return 0
+	case percent > 100:
+		return 100
+	default:
+		return percent
+	}
+}
+
+// InRollout returns true if the install is in the cohort for a version,
+// released to percent of installs.
+//
+// An install's position (0-99) for a version is from a hash of the install ID
+// and version, so it doesn't change as the rollout percentage increases, and
+// installs aren't always the first (or last) to get an update.
+// If there is no install ID, the install is only in a full (100%) rollout.
+func InRollout(installID string, version string, percent int) bool {
+	if percent >= 100 {
+		return true
+	}
+	if percent <= 0 || installID == "" {
+		return false
+	}
+	h := sha256.Sum256([]byte(installID + ":" + version))
+	return binary.BigEndian.Uint64(h[:8])%100 < uint64(percent)
+}
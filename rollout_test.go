@@ -0,0 +1,76 @@
+package updater
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func rollout(percent int) *int {
+	return &percent
+}
+
+func TestRolloutPercent(t *testing.T) {
+	require.Equal(t, 100, Update{}.RolloutPercent())
+	require.Equal(t, 5, Update{Rollout: rollout(5)}.RolloutPercent())
+	require.Equal(t, 0, Update{Rollout: rollout(0)}.RolloutPercent())
+	require.Equal(t, 100, Update{Rollout: rollout(101)}.RolloutPercent())
+	require.Equal(t, 25, Update{Props: []Property{{Name: "rollout", Value: "25%"}}}.RolloutPercent())
+	require.Equal(t, 0, Update{Props: []Property{{Name: "rollout", Value: "0"}}}.RolloutPercent())
+	require.Equal(t, 100, Update{Props: []Property{{Name: "rollout", Value: "invalid"}}}.RolloutPercent())
+	// Rollout takes precedence over props
+	require.Equal(t, 5, Update{Rollout: rollout(5), Props: []Property{{Name: "rollout", Value: "25"}}}.RolloutPercent())
+	require.Equal(t, 0, Update{Rollout: rollout(0), Props: []Property{{Name: "rollout", Value: "25"}}}.RolloutPercent())
+
+	var upd Update
+	err := json.Unmarshal([]byte(`{"version":"1.0.1","rollout":0}`), &upd)
+	require.NoError(t, err)
+	require.Equal(t, 0, upd.RolloutPercent())
+}
+
+func TestInRollout(t *testing.T) {
+	// install5 is at 25 (for 1.0.1)
+	require.False(t, InRollout("install5", "1.0.1", 5))
+	require.False(t, InRollout("install5", "1.0.1", 25))
+	require.True(t, InRollout("install5", "1.0.1", 26))
+	require.True(t, InRollout("install5", "1.0.1", 100))
+	require.False(t, InRollout("install5", "1.0.1", 0))
+	require.False(t, InRollout("", "1.0.1", 99))
+	require.True(t, InRollout("", "1.0.1", 100))
+
+	count := 0
+	for i := 0; i < 1000; i++ {
+		if InRollout(fmt.Sprintf("install%d", i), "1.0.1", 25) {
+			count++
+		}
+	}
+	require.InDelta(t, 250, count, 50)
+}
+
+func TestCheckForUpdateRollout(t *testing.T) {
+	update := newTestUpdate("", true)
+	update.Rollout = rollout(30)
+	upr := NewUpdater(testUpdateSource{update: update})
+
+	options := testUpdateOptions()
+	options.InstallID = "install1"
+	upd, err := upr.CheckForUpdate(context.TODO(), options)
+	require.NoError(t, err)
+	require.False(t, upd.NeedUpdate)
+
+	update.NeedUpdate = true
+	options.InstallID = "install5"
+	upd, err = upr.CheckForUpdate(context.TODO(), options)
+	require.NoError(t, err)
+	require.True(t, upd.NeedUpdate)
+
+	// Explicit 0 isn't released to any installs
+	update.NeedUpdate = true
+	update.Rollout = rollout(0)
+	upd, err = upr.CheckForUpdate(context.TODO(), options)
+	require.NoError(t, err)
+	require.False(t, upd.NeedUpdate)
+}
@@ -0,0 +1,133 @@
+package updater
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/keys-pub/updater/util"
+)
+
+// State is the persistent updater state for an app, so an app can find out
+// what happened the last time the updater ran.
+type State struct {
+	path string
+
+	// InstallID is a random, stable ID for the install (for staged rollouts).
+	InstallID string `json:"installId,omitempty"`
+	// LastCheck is when we last checked for an update.
+	LastCheck time.Time `json:"lastCheck"`
+	// LastVersion is the version of the update we last found.
+	LastVersion string `json:"lastVersion,omitempty"`
+	// DownloadPath is the path of the last downloaded update asset.
+	DownloadPath string `json:"downloadPath,omitempty"`
+	// DownloadDigest is the digest of the last downloaded update asset.
+	DownloadDigest string `json:"downloadDigest,omitempty"`
+	// LastApply is the result of the last apply.
+	LastApply *ApplyResult `json:"lastApply,omitempty"`
+	// LastError is the error from the last check, download or apply.
+	LastError string `json:"lastError,omitempty"`
+	// ConsecutiveFailures is the number of failed checks, downloads or applies
+	// (since the last success).
+	ConsecutiveFailures int `json:"consecutiveFailures"`
+}
+
+// ApplyResult is the result of applying an update.
+type ApplyResult struct {
+	Version string    `json:"version"`
+	Path    string    `json:"path"`
+	Time    time.Time `json:"time"`
+	Error   string    `json:"error,omitempty"`
+	// RolledBack is true if the apply failed and the previous install was
+	// restored.
+	RolledBack bool `json:"rolledBack,omitempty"`
+}
+
+// LoadState loads the state (state.json) from dir, see DataDir.
+// If there is no state (or it's invalid), returns an empty state.
+func LoadState(dir string) (*State, error) {
+	state := &State{path: filepath.Join(dir, "state.json")}
+	b, err := ioutil.ReadFile(state.path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, state); err != nil {
+		logger.Warningf("Invalid state %s: %v", state.path, err)
+		return &State{path: state.path}, nil
+	}
+	return state, nil
+}
+
+// Save the state (atomically).
+func (s *State) Save() error {
+	if err := util.MakeParentDirs(s.path, 0700); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return util.NewFile(s.path, b, 0600).Save()
+}
+
+// EnsureInstallID returns the install ID, creating it if needed.
+// You need to Save the state to persist a new ID.
+func (s *State) EnsureInstallID() (string, error) {
+	if s.InstallID == "" {
+		id, err := util.RandomID("")
+		if err != nil {
+			return "", err
+		}
+		s.InstallID = id
+	}
+	return s.InstallID, nil
+}
+
+func (s *State) result(err error) {
+	if err != nil {
+		s.LastError = err.Error()
+		s.ConsecutiveFailures++
+		return
+	}
+	s.LastError = ""
+	s.ConsecutiveFailures = 0
+}
+
+// RecordCheck records the result of CheckForUpdate.
+func (s *State) RecordCheck(update *Update, err error) {
+	s.LastCheck = time.Now()
+	if err == nil && update != nil {
+		s.LastVersion = update.Version
+	}
+	s.result(err)
+}
+
+// RecordDownload records the result of Download.
+func (s *State) RecordDownload(update *Update, err error) {
+	if err == nil && update.Asset != nil {
+		s.DownloadPath = update.Asset.LocalPath
+		s.DownloadDigest = update.Asset.Digest
+	}
+	s.result(err)
+}
+
+// RecordApply records the result of applying an update to path.
+func (s *State) RecordApply(update *Update, path string, err error) {
+	s.LastApply = &ApplyResult{
+		Version: update.Version,
+		Path:    path,
+		Time:    time.Now(),
+	}
+	if err != nil {
+		s.LastApply.Error = err.Error()
+		if ierr, ok := err.(InstallError); ok {
+			s.LastApply.RolledBack = ierr.RolledBack
+		}
+	}
+	s.result(err)
+}
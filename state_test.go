@@ -0,0 +1,59 @@
+package updater
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/keys-pub/updater/util"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestState(t *testing.T) {
+	dir, err := util.MakeTempDir("TestState.", 0700)
+	require.NoError(t, err)
+	defer util.RemoveFileAtPath(dir)
+
+	state, err := LoadState(dir)
+	require.NoError(t, err)
+	require.True(t, state.LastCheck.IsZero())
+	installID, err := state.EnsureInstallID()
+	require.NoError(t, err)
+	require.NotEmpty(t, installID)
+
+	update := &Update{Version: "1.0.1", Asset: &Asset{LocalPath: "/tmp/Keys-1.0.1.zip", Digest: "abc"}}
+	state.RecordCheck(update, nil)
+	state.RecordDownload(update, errors.Errorf("Download failed"))
+	require.Equal(t, 1, state.ConsecutiveFailures)
+	require.Equal(t, "Download failed", state.LastError)
+	state.RecordDownload(update, nil)
+	state.RecordApply(update, "/Applications/Keys.app", InstallError{Err: errors.Errorf("Health check failed"), RolledBack: true})
+	err = state.Save()
+	require.NoError(t, err)
+
+	loaded, err := LoadState(dir)
+	require.NoError(t, err)
+	require.False(t, loaded.LastCheck.IsZero())
+	id, err := loaded.EnsureInstallID()
+	require.NoError(t, err)
+	require.Equal(t, installID, id)
+	require.Equal(t, "1.0.1", loaded.LastVersion)
+	require.Equal(t, "/tmp/Keys-1.0.1.zip", loaded.DownloadPath)
+	require.Equal(t, "abc", loaded.DownloadDigest)
+	require.Equal(t, "/Applications/Keys.app", loaded.LastApply.Path)
+	require.Equal(t, "Install failed (rolled back): Health check failed", loaded.LastApply.Error)
+	require.True(t, loaded.LastApply.RolledBack)
+	require.Equal(t, 1, loaded.ConsecutiveFailures)
+
+	loaded.RecordApply(update, "/Applications/Keys.app", nil)
+	require.Equal(t, 0, loaded.ConsecutiveFailures)
+	require.Equal(t, "", loaded.LastApply.Error)
+
+	// Invalid state
+	err = ioutil.WriteFile(filepath.Join(dir, "state.json"), []byte("invalid"), 0600)
+	require.NoError(t, err)
+	state, err = LoadState(dir)
+	require.NoError(t, err)
+	require.Equal(t, "", state.LastVersion)
+}
@@ -4,10 +4,12 @@
 package updater
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/keys-pub/updater/util"
 	"github.com/pkg/errors"
@@ -18,7 +20,10 @@ const Version = "0.2.3"
 
 // Updater knows how to find and apply updates
 type Updater struct {
-	source UpdateSource
+	source   UpdateSource
+	verifier Verifier
+	progress util.ProgressFn
+	retry    util.RetryPolicy
 }
 
 // UpdateSource defines where the updater can find updates
@@ -26,19 +31,39 @@ type UpdateSource interface {
 	// Description is a short description about the update source
 	Description() string
 	// FindUpdate finds an update given options
-	FindUpdate(options UpdateOptions) (*Update, error)
+	FindUpdate(ctx context.Context, options UpdateOptions) (*Update, error)
 }
 
 // NewUpdater constructs an Updater
 func NewUpdater(source UpdateSource) *Updater {
 	return &Updater{
 		source: source,
+		retry:  util.DefaultRetryPolicy,
 	}
 }
 
+// SetVerifier sets a Verifier for downloaded assets.
+// If set, downloads without a valid signature are rejected.
+func (u *Updater) SetVerifier(verifier Verifier) {
+	u.verifier = verifier
+}
+
+// SetRetryPolicy sets the policy for retrying failed downloads (defaults to
+// util.DefaultRetryPolicy).
+func (u *Updater) SetRetryPolicy(retry util.RetryPolicy) {
+	u.retry = retry
+}
+
+// SetDownloadProgress sets a function that is called with download progress.
+func (u *Updater) SetDownloadProgress(fn util.ProgressFn) {
+	u.progress = fn
+}
+
 // Download an update.
 // If downloaded update.Asset.LocalPath is set to downloaded path.
-func (u *Updater) Download(update *Update, options UpdateOptions) error {
+// If the context is canceled, the download is stopped and any partial
+// download is removed.
+func (u *Updater) Download(ctx context.Context, update *Update, options UpdateOptions) error {
 	// Linux updates don't have assets so it's ok to prompt for update above before
 	// we check for nil asset.
 	if update.Asset == nil || update.Asset.URL == "" {
@@ -47,53 +72,143 @@ func (u *Updater) Download(update *Update, options UpdateOptions) error {
 	}
 
 	tmpDir := tempDir(options.AppName)
-	if err := u.downloadAsset(update.Asset, tmpDir, options); err != nil {
+	if err := u.downloadAsset(ctx, update.Asset, tmpDir, options); err != nil {
 		return err
 	}
 
+	if err := NewCache(options.AppName).add(update.Asset, update.Version, update.Asset.LocalPath); err != nil {
+		logger.Warningf("Error saving cache record: %v", err)
+	}
+
+	return nil
+}
+
+func parseDigestType(s string) (util.DigestType, error) {
+	switch s {
+	case "", "sha256":
+		return util.SHA256, nil
+	case "sha512":
+		return util.SHA512, nil
+	default:
+		return "", errors.Errorf("Unsupported digest type: %s", s)
+	}
+}
+
+// CheckAssetName returns an error if an asset name isn't a file name (for
+// example, ../../.bashrc), since we download the asset to the app temp
+// directory using its name.
+func CheckAssetName(name string) error {
+	if name == "" || name == "." || name == ".." || name != filepath.Base(name) {
+		return errors.Errorf("Invalid asset name %q", name)
+	}
 	return nil
 }
 
 // downloadAsset will download the update to a temporary path (if not cached),
 // check the digest, and set the LocalPath property on the asset.
-func (u *Updater) downloadAsset(asset *Asset, tmpDir string, options UpdateOptions) error {
+func (u *Updater) downloadAsset(ctx context.Context, asset *Asset, tmpDir string, options UpdateOptions) error {
 	if asset == nil {
 		return fmt.Errorf("No asset to download")
 	}
+	if err := CheckAssetName(asset.Name); err != nil {
+		return err
+	}
 
-	var digestType util.DigestType
-	switch asset.DigestType {
-	case "", "sha256":
-		digestType = util.SHA256
-	case "sha512":
-		digestType = util.SHA512
-	default:
-		return errors.Errorf("Unsupported digest type: %s", asset.DigestType)
+	digestType, err := parseDigestType(asset.DigestType)
+	if err != nil {
+		return err
 	}
 
 	downloadOptions := util.DownloadURLOptions{
 		Digest:     asset.Digest,
 		DigestType: digestType,
 		UseETag:    true,
+		Size:       asset.Size,
+		Progress:   u.progress,
+		Retry:      u.retry,
 	}
 
 	downloadPath := filepath.Join(tmpDir, asset.Name)
-	// If asset had a file extension, lets add it back on
-	if err := util.DownloadURL(asset.URL, downloadPath, downloadOptions); err != nil {
-		return err
+	downloaded := false
+	if asset.Differential != nil {
+		if err := u.downloadDifferential(ctx, asset, tmpDir, downloadPath, downloadOptions); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			logger.Infof("Differential download unavailable, using full download: %v", err)
+		} else {
+			downloaded = true
+		}
+	}
+	if !downloaded {
+		if err := downloadMirrors(ctx, asset, downloadPath, downloadOptions); err != nil {
+			return err
+		}
+	}
+
+	if u.verifier != nil {
+		if err := u.verifier.Verify(asset, downloadPath); err != nil {
+			util.RemoveFileAtPath(downloadPath)
+			return err
+		}
 	}
 
 	asset.LocalPath = downloadPath
 	return nil
 }
 
+// downloadMirrors downloads the asset from its URL, or if that fails, from
+// its mirrors (in order).
+func downloadMirrors(ctx context.Context, asset *Asset, downloadPath string, options util.DownloadURLOptions) error {
+	urls := append([]string{asset.URL}, asset.Mirrors...)
+	var err error
+	for i, urs := range urls {
+		if err = util.DownloadURLContext(ctx, urs, downloadPath, options); err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if i < len(urls)-1 {
+			logger.Warningf("Download from %s failed, trying mirror %s: %v", urs, urls[i+1], err)
+		}
+	}
+	return err
+}
+
+// downloadDifferential downloads only the changed blocks for an asset, using
+// the previous version's asset (if we have it in tmpDir) and blockmaps.
+func (u *Updater) downloadDifferential(ctx context.Context, asset *Asset, tmpDir string, downloadPath string, options util.DownloadURLOptions) error {
+	diff := asset.Differential
+	if exists, err := util.FileExists(downloadPath); err != nil || exists {
+		// Already downloaded, we'll check if it changed (with ETag)
+		return errors.Errorf("Already downloaded")
+	}
+	previousPath := filepath.Join(tmpDir, filepath.Base(diff.PreviousName))
+	if exists, err := util.FileExists(previousPath); err != nil {
+		return err
+	} else if !exists {
+		return errors.Errorf("No previous asset")
+	}
+
+	oldMap, err := util.DownloadBlockMap(ctx, diff.PreviousBlockMapURL, time.Minute)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to get previous blockmap")
+	}
+	newMap, err := util.DownloadBlockMap(ctx, diff.BlockMapURL, time.Minute)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to get blockmap")
+	}
+	return util.DownloadDifferential(ctx, asset.URL, downloadPath, previousPath, oldMap, newMap, options)
+}
+
 // CheckForUpdate checks a update source for an update.
-func (u *Updater) CheckForUpdate(options UpdateOptions) (*Update, error) {
+func (u *Updater) CheckForUpdate(ctx context.Context, options UpdateOptions) (*Update, error) {
 	logger.Infof("Checking for update, current version is %s", options.Version)
 	logger.Infof("Using updater source: %s", u.source.Description())
 	logger.Debugf("Using options: %#v", options)
 
-	update, findErr := u.source.FindUpdate(options)
+	update, findErr := u.source.FindUpdate(ctx, options)
 	if findErr != nil {
 		return nil, findErr
 	}
@@ -101,6 +216,14 @@ func (u *Updater) CheckForUpdate(options UpdateOptions) (*Update, error) {
 		return nil, nil
 	}
 
+	if update.NeedUpdate {
+		percent := update.RolloutPercent()
+		if !InRollout(options.InstallID, update.Version, percent) {
+			logger.Infof("Update %s is rolled out to %d%%, not including this install", update.Version, percent)
+			update.NeedUpdate = false
+		}
+	}
+
 	return update, nil
 }
 
@@ -108,10 +231,25 @@ func tempDir(appName string) string {
 	return filepath.Join(os.TempDir(), "updater", appName)
 }
 
+// DataDir is the directory for updater data (that should persist across runs)
+// for an app, in the user config directory, for example,
+// ~/Library/Application Support/{appName}/updater on macOS.
+func DataDir(appName string) (string, error) {
+	if appName == "" {
+		return "", errors.Errorf("No app name")
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, appName, "updater"), nil
+}
+
 // Cleanup files, except for a path (presumably the update).
 // You can do this after you download an update, so that if the update already
 // exists it doesn't have to be re-downloaded, which removes all other files
 // except the current update.
+// For more control over what is kept, see Cache.
 func Cleanup(appName string, except string) {
 	dir := tempDir(appName)
 
@@ -125,10 +263,11 @@ func Cleanup(appName string, except string) {
 	logger.Infof("Cleanup files (except=%s)...", exceptBase)
 	for _, file := range files {
 		name := file.Name()
-		if name != exceptBase {
-			logger.Infof("Cleanup, removing %s", name)
-			if err := os.RemoveAll(name); err != nil {
-				logger.Errorf("Error removing %s: %v", file, err)
+		if name != exceptBase && name != cacheRecordPath(exceptBase) {
+			path := filepath.Join(dir, name)
+			logger.Infof("Cleanup, removing %s", path)
+			if err := os.RemoveAll(path); err != nil {
+				logger.Errorf("Error removing %s: %v", path, err)
 				return
 			}
 		}
@@ -4,14 +4,24 @@
 package updater
 
 import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/keys-pub/updater/util"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -56,7 +66,7 @@ func newTestUpdate(uri string, needUpdate bool) *Update {
 	return update
 }
 
-func (u testUpdateSource) FindUpdate(options UpdateOptions) (*Update, error) {
+func (u testUpdateSource) FindUpdate(ctx context.Context, options UpdateOptions) (*Update, error) {
 	return u.update, u.findErr
 }
 
@@ -96,7 +106,7 @@ func TestUpdaterCheck(t *testing.T) {
 	upr, err := newTestUpdaterWithServer(t, testServer, testUpdate(testServer.URL))
 	assert.NoError(t, err)
 	options := testUpdateOptions()
-	update, err := upr.CheckForUpdate(options)
+	update, err := upr.CheckForUpdate(context.TODO(), options)
 	require.NoError(t, err)
 	require.NotNil(t, update)
 	t.Logf("Update: %#v\n", update)
@@ -111,11 +121,184 @@ func TestUpdaterDownloadError(t *testing.T) {
 
 	upr, err := newTestUpdaterWithServer(t, testServer, testUpdate(testServer.URL))
 	assert.NoError(t, err)
+	upr.SetRetryPolicy(util.RetryPolicy{Attempts: 2, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond})
 	options := testUpdateOptions()
-	update, err := upr.CheckForUpdate(options)
+	update, err := upr.CheckForUpdate(context.TODO(), options)
 	require.NoError(t, err)
 	require.NotNil(t, update)
-	err = upr.Download(update, options)
+	err = upr.Download(context.TODO(), update, options)
 	assert.EqualError(t, err, "500 Internal Server Error")
 	// TODO: Test
 }
+
+func TestUpdaterDownloadInvalidName(t *testing.T) {
+	testServer := testServerForUpdateFile(t, testZipPath)
+	defer testServer.Close()
+
+	update := testUpdate(testServer.URL)
+	update.Asset.Name = "../../test.zip"
+	upr, err := newTestUpdaterWithServer(t, testServer, update)
+	require.NoError(t, err)
+	options := testUpdateOptions()
+	options.AppName = "TestUpdaterDownloadInvalidName"
+	defer NewCache(options.AppName).Clear()
+	err = upr.Download(context.TODO(), update, options)
+	require.EqualError(t, err, `Invalid asset name "../../test.zip"`)
+	require.Empty(t, update.Asset.LocalPath)
+	exists, err := util.FileExists(filepath.Join(os.TempDir(), "test.zip"))
+	require.NoError(t, err)
+	require.False(t, exists)
+}
+
+func TestUpdaterDownloadMirrors(t *testing.T) {
+	errServer := testServerForError(t, fmt.Errorf("bad response"))
+	defer errServer.Close()
+	notFound := testServerNotFound(t)
+	defer notFound.Close()
+	testServer := testServerForUpdateFile(t, testZipPath)
+	defer testServer.Close()
+
+	update := testUpdate(errServer.URL + "/test.zip")
+	update.Asset.Mirrors = []string{notFound.URL + "/test.zip", testServer.URL + "/test.zip"}
+	upr, err := newTestUpdaterWithServer(t, testServer, update)
+	require.NoError(t, err)
+	upr.SetRetryPolicy(util.RetryPolicy{Attempts: 2, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond})
+	options := testUpdateOptions()
+	options.AppName = "TestUpdaterDownloadMirrors"
+	defer NewCache(options.AppName).Clear()
+
+	err = upr.Download(context.TODO(), update, options)
+	require.NoError(t, err)
+	require.NotEmpty(t, update.Asset.LocalPath)
+
+	// Bad digest from every URL
+	update = testUpdate(testServer.URL + "/test.zip")
+	update.Asset.Name = "test2.zip"
+	update.Asset.Digest = "bad"
+	update.Asset.Mirrors = []string{testServer.URL + "/test2.zip"}
+	err = upr.Download(context.TODO(), update, options)
+	require.EqualError(t, err, fmt.Sprintf("Invalid digest: 54970995e4d02da631e0634162ef66e2663e0eee7d018e816ac48ed6f7811c84 != bad (%s.download)", filepath.Join(NewCache(options.AppName).Dir(), "test2.zip")))
+}
+
+func TestUpdaterDownloadVerify(t *testing.T) {
+	testServer := testServerForUpdateFile(t, testZipPath)
+	defer testServer.Close()
+
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	f, err := os.Open(testZipPath)
+	require.NoError(t, err)
+	defer f.Close()
+	sig, err := Ed25519Sign(privateKey, f)
+	require.NoError(t, err)
+
+	upr, err := newTestUpdaterWithServer(t, testServer, testUpdate(testServer.URL))
+	require.NoError(t, err)
+	upr.SetVerifier(NewEd25519Verifier(publicKey))
+	options := testUpdateOptions()
+
+	// Missing signature
+	update, err := upr.CheckForUpdate(context.TODO(), options)
+	require.NoError(t, err)
+	err = upr.Download(context.TODO(), update, options)
+	require.EqualError(t, err, "Missing signature")
+	require.Equal(t, "", update.Asset.LocalPath)
+
+	// Invalid signature
+	update.Asset.Signature = base64.StdEncoding.EncodeToString(append(Ed25519KeyID(publicKey), make([]byte, 64)...))
+	err = upr.Download(context.TODO(), update, options)
+	require.EqualError(t, err, "Invalid signature")
+
+	// Unknown key
+	otherPublicKey, _, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	upr.SetVerifier(NewEd25519Verifier(otherPublicKey))
+	update.Asset.Signature = sig
+	err = upr.Download(context.TODO(), update, options)
+	require.EqualError(t, err, fmt.Sprintf("Unknown signature key (%x)", Ed25519KeyID(publicKey)))
+
+	// Valid signature
+	upr.SetVerifier(NewEd25519Verifier(otherPublicKey, publicKey))
+	err = upr.Download(context.TODO(), update, options)
+	require.NoError(t, err)
+	require.NotEqual(t, "", update.Asset.LocalPath)
+}
+
+func testBlockMap(t *testing.T, data []byte, chunkSize int) []byte {
+	file := util.BlockMapFile{Name: "file"}
+	for i := 0; i < len(data); i += chunkSize {
+		end := i + chunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		h := sha256.Sum256(data[i:end])
+		file.Checksums = append(file.Checksums, base64.StdEncoding.EncodeToString(h[:]))
+		file.Sizes = append(file.Sizes, int64(end-i))
+	}
+	b, err := json.Marshal(util.BlockMap{Version: "2", Files: []util.BlockMapFile{file}})
+	require.NoError(t, err)
+	return b
+}
+
+func TestUpdaterDownloadDifferential(t *testing.T) {
+	data, err := ioutil.ReadFile(testZipPath)
+	require.NoError(t, err)
+	// Previous version differs in the first 1000 bytes
+	previous := append(bytes.Repeat([]byte{0}, 1000), data[1000:]...)
+
+	var ranges []string
+	blockMapFound := true
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/test.zip":
+			ranges = append(ranges, r.Header.Get("Range"))
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+		case "/test.zip.blockmap":
+			if !blockMapFound {
+				http.Error(w, "Not Found", 404)
+				return
+			}
+			_, _ = w.Write(testBlockMap(t, data, 1000))
+		case "/previous.zip.blockmap":
+			_, _ = w.Write(testBlockMap(t, previous, 1000))
+		default:
+			http.Error(w, "Not Found", 404)
+		}
+	}))
+	defer testServer.Close()
+
+	options := testUpdateOptions()
+	options.AppName = "TestUpdaterDownloadDifferential"
+	tmpDir := tempDir(options.AppName)
+	defer util.RemoveFileAtPath(tmpDir)
+	err = util.MakeDirs(tmpDir, 0700)
+	require.NoError(t, err)
+	err = ioutil.WriteFile(filepath.Join(tmpDir, "previous.zip"), previous, 0600)
+	require.NoError(t, err)
+
+	update := testUpdate(testServer.URL + "/test.zip")
+	update.Asset.Differential = &Differential{
+		BlockMapURL:         testServer.URL + "/test.zip.blockmap",
+		PreviousName:        "previous.zip",
+		PreviousBlockMapURL: testServer.URL + "/previous.zip.blockmap",
+	}
+	upr, err := newTestUpdaterWithServer(t, testServer, update)
+	require.NoError(t, err)
+	err = upr.Download(context.TODO(), update, options)
+	require.NoError(t, err)
+	require.Equal(t, []string{"bytes=0-999"}, ranges)
+	b, err := ioutil.ReadFile(update.Asset.LocalPath)
+	require.NoError(t, err)
+	require.Equal(t, data, b)
+
+	// Falls back to full download
+	util.RemoveFileAtPath(update.Asset.LocalPath)
+	ranges = nil
+	blockMapFound = false
+	err = upr.Download(context.TODO(), update, options)
+	require.NoError(t, err)
+	require.Equal(t, []string{""}, ranges)
+	b, err = ioutil.ReadFile(update.Asset.LocalPath)
+	require.NoError(t, err)
+	require.Equal(t, data, b)
+}
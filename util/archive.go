@@ -0,0 +1,398 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package util
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ArchiveError is returned if an archive is unsafe to extract, for example,
+// an entry would be written outside the destination, or the archive exceeds
+// ArchiveLimits.
+type ArchiveError struct {
+	// Name is the archive entry name (if any).
+	Name string
+	// Reason the archive is unsafe.
+	Reason string
+}
+
+func (e ArchiveError) Error() string {
+	if e.Name == "" {
+		return fmt.Sprintf("Unsafe archive: %s", e.Reason)
+	}
+	return fmt.Sprintf("Unsafe archive entry %q: %s", e.Name, e.Reason)
+}
+
+// ArchiveLimits are limits when extracting an archive (to protect against zip
+// bombs). A zero value means no limit.
+type ArchiveLimits struct {
+	// MaxEntries is the maximum number of entries.
+	MaxEntries int
+	// MaxSize is the maximum total uncompressed size.
+	MaxSize int64
+	// MaxRatio is the maximum compression ratio for an entry (for entries
+	// larger than 1MB).
+	MaxRatio int64
+}
+
+// DefaultArchiveLimits are the limits used by Unzip.
+var DefaultArchiveLimits = ArchiveLimits{
+	MaxEntries: 100000,
+	MaxSize:    4 << 30,
+	MaxRatio:   200,
+}
+
+// minRatioSize is the size at which we check the compression ratio (small
+// files can be very compressible).
+const minRatioSize = 1 << 20
+
+// checkEntries checks the number of entries.
+func (l ArchiveLimits) checkEntries(n int) error {
+	if l.MaxEntries > 0 && n > l.MaxEntries {
+		return ArchiveError{Reason: fmt.Sprintf("too many entries (%d > %d)", n, l.MaxEntries)}
+	}
+	return nil
+}
+
+// checkRatio checks the compression ratio of an entry.
+func (l ArchiveLimits) checkRatio(name string, compressedSize int64, size int64) error {
+	if l.MaxRatio <= 0 || size < minRatioSize {
+		return nil
+	}
+	if compressedSize <= 0 || size/compressedSize > l.MaxRatio {
+		return ArchiveError{Name: name, Reason: fmt.Sprintf("compression ratio exceeds %d", l.MaxRatio)}
+	}
+	return nil
+}
+
+// archiveSizeReader reads from an entry, failing if the total (uncompressed)
+// size exceeds the limit.
+type archiveSizeReader struct {
+	r     io.Reader
+	name  string
+	total *int64
+	max   int64
+}
+
+func (r archiveSizeReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	*r.total += int64(n)
+	if r.max > 0 && *r.total > r.max {
+		return n, ArchiveError{Name: r.name, Reason: fmt.Sprintf("total size exceeds %d", r.max)}
+	}
+	return n, err
+}
+
+// archiveEntryPath returns the path for an archive entry in destinationPath,
+// or an error if the entry name is absolute or would be outside of
+// destinationPath.
+func archiveEntryPath(destinationPath string, name string) (string, error) {
+	slashed := strings.Replace(name, `\`, "/", -1)
+	if strings.HasPrefix(slashed, "/") || filepath.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return "", ArchiveError{Name: name, Reason: "absolute path"}
+	}
+	for _, elem := range strings.Split(slashed, "/") {
+		if elem == ".." {
+			return "", ArchiveError{Name: name, Reason: "path outside of destination"}
+		}
+	}
+	path := filepath.Join(destinationPath, filepath.FromSlash(slashed))
+	if !isPathWithin(destinationPath, path) {
+		return "", ArchiveError{Name: name, Reason: "path outside of destination"}
+	}
+	return path, nil
+}
+
+// isPathWithin returns true if path is dir or in dir (lexically).
+func isPathWithin(dir string, path string) bool {
+	rel, err := filepath.Rel(filepath.Clean(dir), filepath.Clean(path))
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel))
+}
+
+// checkArchiveSymlink returns an error if a symlink at path (in
+// destinationPath) with target would point outside of destinationPath.
+func checkArchiveSymlink(destinationPath string, name string, path string, target string) error {
+	if target == "" {
+		return ArchiveError{Name: name, Reason: "empty symlink"}
+	}
+	if filepath.IsAbs(target) || strings.HasPrefix(target, "/") || filepath.VolumeName(target) != "" {
+		return ArchiveError{Name: name, Reason: "absolute symlink"}
+	}
+	resolved := filepath.Join(filepath.Dir(path), filepath.FromSlash(target))
+	if !isPathWithin(destinationPath, resolved) {
+		return ArchiveError{Name: name, Reason: "symlink outside of destination"}
+	}
+	return nil
+}
+
+// checkArchiveParents returns an error if path, or any of its parents (in
+// destinationPath) is a symlink, so we never write through a symlink.
+func checkArchiveParents(destinationPath string, name string, path string) error {
+	rel, err := filepath.Rel(destinationPath, path)
+	if err != nil {
+		return err
+	}
+	current := destinationPath
+	for _, elem := range strings.Split(rel, string(filepath.Separator)) {
+		if elem == "." || elem == "" {
+			continue
+		}
+		current = filepath.Join(current, elem)
+		fileInfo, err := os.Lstat(current)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if fileInfo.Mode()&os.ModeSymlink != 0 {
+			return ArchiveError{Name: name, Reason: "path through symlink"}
+		}
+	}
+	return nil
+}
+
+// checkArchiveSymlinks checks symlinks (after extracting) resolve within
+// destinationPath. Symlinks that don't resolve (dangling) are allowed.
+func checkArchiveSymlinks(destinationPath string, symlinks map[string]string) error {
+	realDestination, err := filepath.EvalSymlinks(destinationPath)
+	if err != nil {
+		return err
+	}
+	for path, name := range symlinks {
+		resolved, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			continue
+		}
+		if !isPathWithin(realDestination, resolved) {
+			return ArchiveError{Name: name, Reason: "symlink outside of destination"}
+		}
+	}
+	return nil
+}
+
+// UnzipOptions are options for extracting archives, see UnzipWithOptions and
+// ExtractWithOptions.
+type UnzipOptions struct {
+	// Limits for the archive, see ArchiveLimits.
+	Limits ArchiveLimits
+	// PreserveModTime sets modification times from the archive, otherwise
+	// files have the current time.
+	PreserveModTime bool
+	// PreserveExec sets permissions to 0755 for directories and executables in
+	// the archive, and 0644 for other files (regardless of umask).
+	PreserveExec bool
+	// StripModes removes setuid, setgid, sticky and world-writable bits.
+	StripModes bool
+	// DigestType for the digests in the manifest, defaults to SHA256.
+	DigestType DigestType
+}
+
+// ArchiveEntry describes an extracted file (see UnzipWithOptions and ExtractWithOptions).
+type ArchiveEntry struct {
+	// Path relative to the destination (with forward slashes).
+	Path string      `json:"path"`
+	Mode os.FileMode `json:"mode"`
+	Size int64       `json:"size"`
+	// Digest of the file contents (for regular files).
+	Digest string `json:"digest,omitempty"`
+}
+
+// mode returns the mode for an archive entry with options.
+func (o UnzipOptions) mode(mode os.FileMode) os.FileMode {
+	if o.PreserveExec {
+		perm := os.FileMode(0644)
+		if mode.IsDir() || mode&0111 != 0 {
+			perm = 0755
+		}
+		mode = mode&^os.ModePerm | perm
+	}
+	if o.StripModes {
+		mode &^= os.ModeSetuid | os.ModeSetgid | os.ModeSticky | 0002
+	}
+	return mode
+}
+
+// archiveExtractor writes archive entries to a destination, checking the
+// entries are safe (see ArchiveError).
+type archiveExtractor struct {
+	destinationPath string
+	options         UnzipOptions
+
+	entries  int
+	written  int64
+	symlinks map[string]string
+	modTimes map[string]time.Time
+	digests  map[string]string
+}
+
+func newArchiveExtractor(destinationPath string, options UnzipOptions) (*archiveExtractor, error) {
+	if err := os.MkdirAll(destinationPath, 0755); err != nil {
+		return nil, err
+	}
+	return &archiveExtractor{
+		destinationPath: destinationPath,
+		options:         options,
+		symlinks:        map[string]string{},
+		modTimes:        map[string]time.Time{},
+		digests:         map[string]string{},
+	}, nil
+}
+
+// path returns the (safe) path for an entry.
+func (x *archiveExtractor) path(name string) (string, error) {
+	x.entries++
+	if err := x.options.Limits.checkEntries(x.entries); err != nil {
+		return "", err
+	}
+	path, err := archiveEntryPath(x.destinationPath, name)
+	if err != nil {
+		return "", err
+	}
+	if err := checkArchiveParents(x.destinationPath, name, path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+func (x *archiveExtractor) chmod(path string, mode os.FileMode) error {
+	if x.options.PreserveExec || x.options.StripModes {
+		return os.Chmod(path, mode)
+	}
+	return nil
+}
+
+func (x *archiveExtractor) dir(name string, mode os.FileMode, modTime time.Time) error {
+	path, err := x.path(name)
+	if err != nil {
+		return err
+	}
+	mode = x.options.mode(mode)
+	x.modTimes[path] = modTime
+	if err := os.MkdirAll(path, mode); err != nil {
+		return err
+	}
+	return x.chmod(path, mode)
+}
+
+func (x *archiveExtractor) file(name string, mode os.FileMode, modTime time.Time, r io.Reader) error {
+	path, err := x.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	mode = x.options.mode(mode)
+	x.modTimes[path] = modTime
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	defer Close(f)
+
+	hasher := newDigestHasher(x.options.DigestType)
+	sr := archiveSizeReader{r: r, name: name, total: &x.written, max: x.options.Limits.MaxSize}
+	if _, err := io.Copy(io.MultiWriter(f, hasher), sr); err != nil {
+		return err
+	}
+	x.digests[path] = hex.EncodeToString(hasher.Sum(nil))
+	return x.chmod(path, mode)
+}
+
+func (x *archiveExtractor) symlink(name string, target string) error {
+	path, err := x.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := checkArchiveSymlink(x.destinationPath, name, path, target); err != nil {
+		return err
+	}
+	x.symlinks[path] = name
+	return os.Symlink(target, path)
+}
+
+// link creates a hard link to a (previously extracted) entry.
+func (x *archiveExtractor) link(name string, target string) error {
+	path, err := x.path(name)
+	if err != nil {
+		return err
+	}
+	targetPath, err := archiveEntryPath(x.destinationPath, target)
+	if err != nil {
+		return ArchiveError{Name: name, Reason: "link outside of destination"}
+	}
+	if err := checkArchiveParents(x.destinationPath, name, targetPath); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if digest, ok := x.digests[targetPath]; ok {
+		x.digests[path] = digest
+	}
+	return os.Link(targetPath, path)
+}
+
+// finish checks symlinks, sets modification times (if preserving) and
+// returns the manifest.
+func (x *archiveExtractor) finish() ([]ArchiveEntry, error) {
+	if err := checkArchiveSymlinks(x.destinationPath, x.symlinks); err != nil {
+		return nil, err
+	}
+
+	if x.options.PreserveModTime {
+		for path, modTime := range x.modTimes {
+			if err := os.Chtimes(path, modTime, modTime); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return archiveManifest(x.destinationPath, x.digests)
+}
+
+// archiveManifest returns entries for the files in destinationPath.
+func archiveManifest(destinationPath string, digests map[string]string) ([]ArchiveEntry, error) {
+	entries := []ArchiveEntry{}
+	err := filepath.Walk(destinationPath, func(path string, fileInfo os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == destinationPath {
+			return nil
+		}
+		rel, err := filepath.Rel(destinationPath, path)
+		if err != nil {
+			return err
+		}
+		entry := ArchiveEntry{
+			Path:   filepath.ToSlash(rel),
+			Mode:   fileInfo.Mode(),
+			Digest: digests[path],
+		}
+		if fileInfo.Mode().IsRegular() {
+			entry.Size = fileInfo.Size()
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
@@ -0,0 +1,140 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package util
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testZipEntry struct {
+	name    string
+	mode    os.FileMode
+	data    []byte
+	modTime time.Time
+}
+
+func testZip(t *testing.T, entries []testZipEntry) string {
+	path := TempPath("", "TestArchive.") + ".zip"
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer Close(f)
+	w := zip.NewWriter(f)
+	for _, entry := range entries {
+		header := &zip.FileHeader{Name: entry.name, Method: zip.Deflate}
+		mode := entry.mode
+		if mode == 0 {
+			mode = 0644
+		}
+		header.SetMode(mode)
+		if !entry.modTime.IsZero() {
+			header.Modified = entry.modTime
+		}
+		fw, err := w.CreateHeader(header)
+		require.NoError(t, err)
+		_, err = fw.Write(entry.data)
+		require.NoError(t, err)
+	}
+	err = w.Close()
+	require.NoError(t, err)
+	return path
+}
+
+func testUnzipUnsafe(t *testing.T, entries []testZipEntry, limits ArchiveLimits) error {
+	path := testZip(t, entries)
+	defer RemoveFileAtPath(path)
+	destinationPath := TempPath("", "TestArchive.")
+	defer RemoveFileAtPath(destinationPath)
+	_, err := UnzipWithOptions(path, destinationPath, UnzipOptions{Limits: limits})
+	if err != nil {
+		_, ok := err.(ArchiveError)
+		require.True(t, ok, "expected ArchiveError, got %T: %v", err, err)
+	}
+	return err
+}
+
+func TestUnzipPathTraversal(t *testing.T) {
+	err := testUnzipUnsafe(t, []testZipEntry{{name: "../evil", data: []byte("evil")}}, DefaultArchiveLimits)
+	require.EqualError(t, err, `Unsafe archive entry "../evil": path outside of destination`)
+
+	err = testUnzipUnsafe(t, []testZipEntry{{name: "a/../../evil", data: []byte("evil")}}, DefaultArchiveLimits)
+	require.EqualError(t, err, `Unsafe archive entry "a/../../evil": path outside of destination`)
+
+	err = testUnzipUnsafe(t, []testZipEntry{{name: "/tmp/evil", data: []byte("evil")}}, DefaultArchiveLimits)
+	require.EqualError(t, err, `Unsafe archive entry "/tmp/evil": absolute path`)
+
+	err = testUnzipUnsafe(t, []testZipEntry{{name: "a/..b", data: []byte("ok")}}, DefaultArchiveLimits)
+	require.NoError(t, err)
+}
+
+func TestUnzipSymlinkEscape(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip()
+	}
+	symlink := os.ModeSymlink | 0777
+
+	err := testUnzipUnsafe(t, []testZipEntry{{name: "link", mode: symlink, data: []byte("../../etc")}}, DefaultArchiveLimits)
+	require.EqualError(t, err, `Unsafe archive entry "link": symlink outside of destination`)
+
+	err = testUnzipUnsafe(t, []testZipEntry{{name: "link", mode: symlink, data: []byte("/etc")}}, DefaultArchiveLimits)
+	require.EqualError(t, err, `Unsafe archive entry "link": absolute symlink`)
+
+	// Symlink (in destination) to a symlink outside
+	err = testUnzipUnsafe(t, []testZipEntry{
+		{name: "dir/", mode: os.ModeDir | 0755},
+		{name: "dir/link", mode: symlink, data: []byte("..")},
+		{name: "link2", mode: symlink, data: []byte("dir/link/..")},
+	}, DefaultArchiveLimits)
+	require.Error(t, err)
+
+	// Writing through a symlink
+	err = testUnzipUnsafe(t, []testZipEntry{
+		{name: "dir/", mode: os.ModeDir | 0755},
+		{name: "link", mode: symlink, data: []byte("dir")},
+		{name: "link/file", data: []byte("evil")},
+	}, DefaultArchiveLimits)
+	require.EqualError(t, err, `Unsafe archive entry "link/file": path through symlink`)
+
+	// Symlink within destination is ok
+	err = testUnzipUnsafe(t, []testZipEntry{
+		{name: "dir/file", data: []byte("ok")},
+		{name: "link", mode: symlink, data: []byte("dir/file")},
+	}, DefaultArchiveLimits)
+	require.NoError(t, err)
+}
+
+func TestUnzipLimits(t *testing.T) {
+	err := testUnzipUnsafe(t, []testZipEntry{
+		{name: "a", data: []byte("a")},
+		{name: "b", data: []byte("b")},
+	}, ArchiveLimits{MaxEntries: 1})
+	require.EqualError(t, err, `Unsafe archive: too many entries (2 > 1)`)
+
+	err = testUnzipUnsafe(t, []testZipEntry{
+		{name: "a", data: []byte("aaaa")},
+		{name: "b", data: []byte("bbbb")},
+	}, ArchiveLimits{MaxSize: 6})
+	require.EqualError(t, err, `Unsafe archive: total size exceeds 6`)
+
+	zeros := bytes.Repeat([]byte{0}, 2*minRatioSize)
+	err = testUnzipUnsafe(t, []testZipEntry{{name: "zeros", data: zeros}}, DefaultArchiveLimits)
+	require.EqualError(t, err, `Unsafe archive entry "zeros": compression ratio exceeds 200`)
+
+	err = testUnzipUnsafe(t, []testZipEntry{{name: "zeros", data: zeros}}, ArchiveLimits{})
+	require.NoError(t, err)
+}
+
+func TestArchiveSizeReader(t *testing.T) {
+	total := int64(0)
+	r := archiveSizeReader{r: bytes.NewReader([]byte("123456")), name: "test", total: &total, max: 4}
+	b := make([]byte, 10)
+	_, err := r.Read(b)
+	require.EqualError(t, err, `Unsafe archive entry "test": total size exceeds 4`)
+}
@@ -0,0 +1,362 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// BlockMap is an electron-builder blockmap, which describes the (content
+// defined) chunks of a file.
+type BlockMap struct {
+	Version string         `json:"version"`
+	Files   []BlockMapFile `json:"files"`
+}
+
+// BlockMapFile is a file in a BlockMap.
+type BlockMapFile struct {
+	Name      string   `json:"name"`
+	Offset    int64    `json:"offset"`
+	Checksums []string `json:"checksums"`
+	Sizes     []int64  `json:"sizes"`
+}
+
+// maxRangesPerRequest is the maximum number of ranges in a multi-range request.
+var maxRangesPerRequest = 100
+
+// maxBlockMapSize is the maximum size of a blockmap (compressed or not).
+var maxBlockMapSize int64 = 32 * 1024 * 1024
+
+// readBlockMap reads (up to maxBlockMapSize) from r.
+func readBlockMap(r io.Reader) ([]byte, error) {
+	b, err := ioutil.ReadAll(io.LimitReader(r, maxBlockMapSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > maxBlockMapSize {
+		return nil, fmt.Errorf("Blockmap is too large (over %d bytes)", maxBlockMapSize)
+	}
+	return b, nil
+}
+
+// ParseBlockMap parses a blockmap, which is JSON and usually gzip compressed.
+func ParseBlockMap(b []byte) (*BlockMap, error) {
+	if len(b) > 2 && b[0] == 0x1f && b[1] == 0x8b {
+		gr, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		defer Close(gr)
+		ub, err := readBlockMap(gr)
+		if err != nil {
+			return nil, err
+		}
+		b = ub
+	}
+	var blockMap BlockMap
+	if err := json.Unmarshal(b, &blockMap); err != nil {
+		return nil, err
+	}
+	if len(blockMap.Files) != 1 {
+		return nil, fmt.Errorf("Unsupported blockmap (%d files)", len(blockMap.Files))
+	}
+	file := blockMap.Files[0]
+	if len(file.Checksums) != len(file.Sizes) {
+		return nil, fmt.Errorf("Invalid blockmap (checksums and sizes mismatch)")
+	}
+	if file.Offset != 0 {
+		return nil, fmt.Errorf("Unsupported blockmap (offset %d)", file.Offset)
+	}
+	return &blockMap, nil
+}
+
+// Size returns the size of the file described by the blockmap.
+func (m BlockMap) Size() int64 {
+	size := m.Files[0].Offset
+	for _, s := range m.Files[0].Sizes {
+		size += s
+	}
+	return size
+}
+
+// DownloadBlockMap requests and parses a blockmap (retrying with
+// DefaultRetryPolicy).
+func DownloadBlockMap(ctx context.Context, urlString string, timeout time.Duration) (*BlockMap, error) {
+	var b []byte
+	err := DefaultRetryPolicy.Do(ctx, func() error {
+		var err error
+		b, err = requestBlockMap(ctx, urlString, timeout)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ParseBlockMap(b)
+}
+
+func requestBlockMap(ctx context.Context, urlString string, timeout time.Duration) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", urlString, nil)
+	if err != nil {
+		return nil, err
+	}
+	logger.Infof("Request %s", urlString)
+	resp, err := HTTPClient(timeout).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer DiscardAndCloseBodyIgnoreError(resp)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, NewHTTPStatusError(resp)
+	}
+	return readBlockMap(resp.Body)
+}
+
+// blockOp is a range of the new file, either copied from the old file (if
+// old is true), or downloaded.
+type blockOp struct {
+	old    bool
+	start  int64 // Offset in old file (if old) or new file
+	size   int64
+	offset int64 // Offset in new file
+}
+
+// blockOps returns the operations to build a file with newMap from a file with
+// oldMap. Adjacent operations are merged.
+func blockOps(oldMap *BlockMap, newMap *BlockMap) []blockOp {
+	type block struct {
+		offset int64
+		size   int64
+	}
+	oldBlocks := map[string]block{}
+	oldFile := oldMap.Files[0]
+	offset := oldFile.Offset
+	for i, checksum := range oldFile.Checksums {
+		if _, ok := oldBlocks[checksum]; !ok {
+			oldBlocks[checksum] = block{offset: offset, size: oldFile.Sizes[i]}
+		}
+		offset += oldFile.Sizes[i]
+	}
+
+	ops := []blockOp{}
+	newFile := newMap.Files[0]
+	offset = newFile.Offset
+	for i, checksum := range newFile.Checksums {
+		size := newFile.Sizes[i]
+		if size == 0 {
+			continue
+		}
+		op := blockOp{start: offset, size: size, offset: offset}
+		if b, ok := oldBlocks[checksum]; ok && b.size == size {
+			op = blockOp{old: true, start: b.offset, size: size, offset: offset}
+		}
+		offset += size
+
+		if len(ops) > 0 {
+			last := &ops[len(ops)-1]
+			if last.old == op.old && last.start+last.size == op.start {
+				last.size += op.size
+				continue
+			}
+		}
+		ops = append(ops, op)
+	}
+	return ops
+}
+
+// DownloadDifferential downloads a URL to a path, using blocks from an old
+// file (at oldPath), so only the changed blocks are downloaded, using HTTP
+// multi-range requests.
+// The result is checked with options.Digest (unless options.SkipDigest).
+// Progress (if options.Progress) includes the blocks copied from the old file.
+func DownloadDifferential(ctx context.Context, urlString string, destinationPath string, oldPath string, oldMap *BlockMap, newMap *BlockMap, options DownloadURLOptions) error {
+	if options.Size > 0 && newMap.Size() != options.Size {
+		return fmt.Errorf("Blockmap size (%d) doesn't match expected size (%d)", newMap.Size(), options.Size)
+	}
+	ops := blockOps(oldMap, newMap)
+
+	savePath := fmt.Sprintf("%s.download", destinationPath)
+	removePartialDownload(savePath)
+	if err := checkFreeSpace(savePath, newMap.Size()); err != nil {
+		return err
+	}
+	if err := MakeParentDirs(savePath, 0700); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(savePath, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	defer Close(out)
+
+	if err := buildDifferential(ctx, urlString, out, oldPath, ops, options); err != nil {
+		Close(out)
+		RemoveFileAtPath(savePath)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		RemoveFileAtPath(savePath)
+		return err
+	}
+
+	if !options.SkipDigest {
+		if err := CheckDigest(options.Digest, savePath, options.DigestType); err != nil {
+			RemoveFileAtPath(savePath)
+			return err
+		}
+	}
+
+	if err := MoveFile(savePath, destinationPath, ""); err != nil {
+		return err
+	}
+	// We don't have validators for the (full) download
+	info := DownloadInfo{URL: urlString}
+	if !options.SkipDigest {
+		info.Digest, info.DigestType = options.Digest, options.DigestType
+	}
+	saveDownloadInfo(info, destinationPath)
+	return nil
+}
+
+func buildDifferential(ctx context.Context, urlString string, out *os.File, oldPath string, ops []blockOp, options DownloadURLOptions) error {
+	old, err := os.Open(oldPath)
+	if err != nil {
+		return err
+	}
+	defer Close(old)
+
+	downloads := []blockOp{}
+	copied, downloaded := int64(0), int64(0)
+	for _, op := range ops {
+		if !op.old {
+			downloads = append(downloads, op)
+			downloaded += op.size
+			continue
+		}
+		if err := copyAt(out, op.offset, io.NewSectionReader(old, op.start, op.size), op.size); err != nil {
+			return err
+		}
+		copied += op.size
+	}
+	logger.Infof("Differential download, copied %d bytes, downloading %d bytes (%d ranges)", copied, downloaded, len(downloads))
+
+	var progress *progressWriter
+	if options.Progress != nil {
+		progress = newProgressWriter(options.Progress, copied, copied+downloaded)
+	}
+	for len(downloads) > 0 {
+		n := len(downloads)
+		if n > maxRangesPerRequest {
+			n = maxRangesPerRequest
+		}
+		if err := downloadRanges(ctx, urlString, out, downloads[:n], progress, options); err != nil {
+			return err
+		}
+		downloads = downloads[n:]
+	}
+	if progress != nil {
+		progress.finish()
+	}
+	return nil
+}
+
+// downloadRanges requests ranges and writes them to out, with progress (if not
+// nil).
+func downloadRanges(ctx context.Context, urlString string, out *os.File, ops []blockOp, progress *progressWriter, options DownloadURLOptions) error {
+	ranges := make([]string, 0, len(ops))
+	byStart := map[int64]blockOp{}
+	for _, op := range ops {
+		ranges = append(ranges, fmt.Sprintf("%d-%d", op.start, op.start+op.size-1))
+		byStart[op.start] = op
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", urlString, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", "bytes="+strings.Join(ranges, ","))
+	client := http.Client{Timeout: options.Timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		// Close without reading, the body might be the full file (if the
+		// server ignored the range request)
+		Close(resp.Body)
+		return fmt.Errorf("Range request failed: %s", resp.Status)
+	}
+	defer DiscardAndCloseBodyIgnoreError(resp)
+
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/byteranges" {
+		// Single range response
+		if len(ops) != 1 {
+			return fmt.Errorf("Range request failed: expected multipart response")
+		}
+		return writeRange(out, resp.Header.Get("Content-Range"), resp.Body, byStart, progress)
+	}
+
+	mr := multipart.NewReader(bufio.NewReader(resp.Body), params["boundary"])
+	count := 0
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := writeRange(out, part.Header.Get("Content-Range"), part, byStart, progress); err != nil {
+			return err
+		}
+		count++
+	}
+	if count != len(ops) {
+		return fmt.Errorf("Range request failed: expected %d parts, got %d", len(ops), count)
+	}
+	return nil
+}
+
+func writeRange(out *os.File, contentRange string, r io.Reader, byStart map[int64]blockOp, progress *progressWriter) error {
+	var start, end int64
+	if _, err := fmt.Sscanf(contentRange, "bytes %d-%d", &start, &end); err != nil {
+		return fmt.Errorf("Invalid Content-Range: %q", contentRange)
+	}
+	op, ok := byStart[start]
+	if !ok || end-start+1 != op.size {
+		return fmt.Errorf("Unexpected Content-Range: %q", contentRange)
+	}
+	if progress != nil {
+		r = io.TeeReader(r, progress)
+	}
+	return copyAt(out, op.offset, r, op.size)
+}
+
+// copyAt copies size bytes from r to out at offset.
+func copyAt(out *os.File, offset int64, r io.Reader, size int64) error {
+	if _, err := out.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	n, err := io.CopyN(out, r, size)
+	if err != nil {
+		return err
+	}
+	if n != size {
+		return fmt.Errorf("Short read (%d != %d)", n, size)
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package util
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// testBlockMap returns a blockmap for data with fixed size chunks.
+func testBlockMap(t *testing.T, data []byte, chunkSize int) []byte {
+	file := BlockMapFile{Name: "file"}
+	for i := 0; i < len(data); i += chunkSize {
+		end := i + chunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		h := sha256.Sum256(data[i:end])
+		file.Checksums = append(file.Checksums, base64.StdEncoding.EncodeToString(h[:]))
+		file.Sizes = append(file.Sizes, int64(end-i))
+	}
+	b, err := json.Marshal(BlockMap{Version: "2", Files: []BlockMapFile{file}})
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	_, err = gw.Write(b)
+	require.NoError(t, err)
+	require.NoError(t, gw.Close())
+	return buf.Bytes()
+}
+
+func TestBlockOps(t *testing.T) {
+	oldData := []byte("aaaabbbbccccdddd")
+	newData := []byte("aaaaxxxxccccddddeeee")
+	oldMap, err := ParseBlockMap(testBlockMap(t, oldData, 4))
+	require.NoError(t, err)
+	newMap, err := ParseBlockMap(testBlockMap(t, newData, 4))
+	require.NoError(t, err)
+	require.Equal(t, int64(20), newMap.Size())
+
+	ops := blockOps(oldMap, newMap)
+	require.Equal(t, []blockOp{
+		{old: true, start: 0, size: 4, offset: 0},
+		{old: false, start: 4, size: 4, offset: 4},
+		{old: true, start: 8, size: 8, offset: 8},
+		{old: false, start: 16, size: 4, offset: 16},
+	}, ops)
+}
+
+func TestDownloadDifferential(t *testing.T) {
+	oldData := []byte(strings.Repeat("a", 100) + strings.Repeat("b", 100) + strings.Repeat("c", 100))
+	newData := []byte(strings.Repeat("a", 100) + strings.Repeat("x", 100) + strings.Repeat("c", 100) + strings.Repeat("y", 100))
+	digest, err := Digest512(bytes.NewReader(newData))
+	require.NoError(t, err)
+
+	var ranges []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(newData))
+	}))
+	defer server.Close()
+
+	oldPath, err := WriteTempFile("TestDownloadDifferential.", oldData, 0600)
+	require.NoError(t, err)
+	defer RemoveFileAtPath(oldPath)
+	destinationPath := TempPath("", "TestDownloadDifferential.")
+	defer RemoveFileAtPath(destinationPath)
+
+	oldMap, err := ParseBlockMap(testBlockMap(t, oldData, 100))
+	require.NoError(t, err)
+	newMap, err := ParseBlockMap(testBlockMap(t, newData, 100))
+	require.NoError(t, err)
+
+	var progress []Progress
+	options := DownloadURLOptions{Digest: digest, DigestType: SHA512, Progress: func(p Progress) {
+		progress = append(progress, p)
+	}}
+	err = DownloadDifferential(context.TODO(), server.URL, destinationPath, oldPath, oldMap, newMap, options)
+	require.NoError(t, err)
+	require.Equal(t, []string{"bytes=100-199,300-399"}, ranges)
+	// Copied blocks count as done
+	require.NotEmpty(t, progress)
+	require.Equal(t, int64(400), progress[len(progress)-1].Done)
+	require.Equal(t, int64(400), progress[len(progress)-1].Total)
+	options.Progress = nil
+	b, err := ioutil.ReadFile(destinationPath)
+	require.NoError(t, err)
+	require.Equal(t, newData, b)
+
+	// Single range
+	ranges = nil
+	RemoveFileAtPath(destinationPath)
+	oldPath2, err := WriteTempFile("TestDownloadDifferential.", newData[:300], 0600)
+	require.NoError(t, err)
+	defer RemoveFileAtPath(oldPath2)
+	oldMap, err = ParseBlockMap(testBlockMap(t, newData[:300], 100))
+	require.NoError(t, err)
+	err = DownloadDifferential(context.TODO(), server.URL, destinationPath, oldPath2, oldMap, newMap, options)
+	require.NoError(t, err)
+	require.Equal(t, []string{"bytes=300-399"}, ranges)
+	b, err = ioutil.ReadFile(destinationPath)
+	require.NoError(t, err)
+	require.Equal(t, newData, b)
+
+	// Old file doesn't match its blockmap
+	RemoveFileAtPath(destinationPath)
+	err = DownloadDifferential(context.TODO(), server.URL, destinationPath, oldPath, oldMap, newMap, options)
+	require.Error(t, err)
+	exists, err := FileExists(destinationPath)
+	require.NoError(t, err)
+	require.False(t, exists)
+}
+
+func TestDownloadBlockMap(t *testing.T) {
+	data := []byte(strings.Repeat("a", 100) + strings.Repeat("b", 100))
+	blockMap := testBlockMap(t, data, 100)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/file.blockmap" {
+			http.Error(w, "Not Found", 404)
+			return
+		}
+		_, _ = w.Write(blockMap)
+	}))
+	defer server.Close()
+
+	m, err := DownloadBlockMap(context.TODO(), server.URL+"/file.blockmap", time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, int64(200), m.Size())
+
+	_, err = DownloadBlockMap(context.TODO(), server.URL+"/missing.blockmap", time.Minute)
+	require.EqualError(t, err, "404 Not Found")
+	serr, ok := errors.Cause(err).(HTTPStatusError)
+	require.True(t, ok)
+	require.Equal(t, 404, serr.StatusCode)
+
+	maxBlockMapSize = 10
+	defer func() { maxBlockMapSize = 32 * 1024 * 1024 }()
+	_, err = DownloadBlockMap(context.TODO(), server.URL+"/file.blockmap", time.Minute)
+	require.EqualError(t, err, "Blockmap is too large (over 10 bytes)")
+}
+
+func TestDownloadRangesIgnored(t *testing.T) {
+	// Server ignores the range request, and responds with the full file
+	size := 64 * 1024 * 1024
+	chunk := make([]byte, 1024*1024)
+	served := make(chan int, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(size))
+		n := 0
+		for n < size {
+			c, err := w.Write(chunk)
+			n += c
+			if err != nil {
+				break
+			}
+		}
+		served <- n
+	}))
+	defer server.Close()
+
+	out, err := ioutil.TempFile("", "TestDownloadRangesIgnored.")
+	require.NoError(t, err)
+	defer RemoveFileAtPath(out.Name())
+	defer out.Close()
+
+	ops := []blockOp{{start: 100, size: 100, offset: 100}}
+	err = downloadRanges(context.TODO(), server.URL, out, ops, nil, DownloadURLOptions{})
+	require.EqualError(t, err, "Range request failed: 200 OK")
+	select {
+	case n := <-served:
+		require.Less(t, n, size)
+	case <-time.After(10 * time.Second):
+		t.Fatal("Server is still sending the full file")
+	}
+}
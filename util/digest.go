@@ -8,6 +8,7 @@ import (
 	"crypto/sha512"
 	"encoding/hex"
 	"fmt"
+	"hash"
 	"io"
 	"os"
 
@@ -33,6 +34,10 @@ func CheckDigest(digest string, path string, typ DigestType) error {
 	if err != nil {
 		return err
 	}
+	return checkDigest(digest, calcDigest, path)
+}
+
+func checkDigest(digest string, calcDigest string, path string) error {
 	if calcDigest != digest {
 		return fmt.Errorf("Invalid digest: %s != %s (%s)", calcDigest, digest, path)
 	}
@@ -40,6 +45,64 @@ func CheckDigest(digest string, path string, typ DigestType) error {
 	return nil
 }
 
+// DigestWriter computes digests (of one or more types) for what is written
+// to it, for example, to check a download as it's saved, instead of reading
+// it again:
+//
+//   digests := NewDigestWriter(SHA256, SHA512)
+//   io.Copy(io.MultiWriter(file, digests), r)
+//   digests.Digest(SHA256)
+type DigestWriter struct {
+	hashes map[DigestType]hash.Hash
+}
+
+// NewDigestWriter returns a DigestWriter for digest types.
+func NewDigestWriter(types ...DigestType) *DigestWriter {
+	hashes := map[DigestType]hash.Hash{}
+	for _, typ := range types {
+		switch typ {
+		case SHA256, "":
+			hashes[SHA256] = sha256.New()
+		case SHA512:
+			hashes[SHA512] = sha512.New()
+		}
+	}
+	return &DigestWriter{hashes: hashes}
+}
+
+func (w *DigestWriter) Write(p []byte) (int, error) {
+	for _, h := range w.hashes {
+		// Hash writes never return an error
+		_, _ = h.Write(p)
+	}
+	return len(p), nil
+}
+
+// Digest returns the (hex encoded) digest for a type.
+func (w *DigestWriter) Digest(typ DigestType) (string, error) {
+	if typ == "" {
+		typ = SHA256
+	}
+	h, ok := w.hashes[typ]
+	if !ok {
+		return "", errors.Errorf("invalid digest type: %s", typ)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Check returns no error if digest matches, like CheckDigest for the file at
+// path we wrote.
+func (w *DigestWriter) Check(digest string, typ DigestType, path string) error {
+	if digest == "" {
+		return fmt.Errorf("Missing digest")
+	}
+	calcDigest, err := w.Digest(typ)
+	if err != nil {
+		return err
+	}
+	return checkDigest(digest, calcDigest, path)
+}
+
 // DigestForFileAtPath returns a SHA256 digest for file at specified path
 func DigestForFileAtPath(path string, typ DigestType) (string, error) {
 	f, err := os.Open(path)
@@ -58,6 +121,14 @@ func DigestForFileAtPath(path string, typ DigestType) (string, error) {
 	}
 }
 
+// newDigestHasher returns a hash for a digest type (SHA256 if unknown).
+func newDigestHasher(typ DigestType) hash.Hash {
+	if typ == SHA512 {
+		return sha512.New()
+	}
+	return sha256.New()
+}
+
 // Digest256 returns a SHA256 digest.
 func Digest256(r io.Reader) (string, error) {
 	hasher := sha256.New()
@@ -33,3 +33,26 @@ func TestDigestInvalidPath(t *testing.T) {
 	t.Logf("Error: %#v", err)
 	assert.Error(t, err)
 }
+
+func TestDigestWriter(t *testing.T) {
+	digests := NewDigestWriter(SHA256, SHA512)
+	_, err := digests.Write([]byte("test "))
+	assert.NoError(t, err)
+	_, err = digests.Write([]byte("data\n"))
+	assert.NoError(t, err)
+
+	digest, err := digests.Digest(SHA256)
+	assert.NoError(t, err)
+	assert.Equal(t, "0c15e883dee85bb2f3540a47ec58f617a2547117f9096417ba5422268029f501", digest)
+	err = digests.Check("0c15e883dee85bb2f3540a47ec58f617a2547117f9096417ba5422268029f501", "", "test")
+	assert.NoError(t, err)
+	err = digests.Check("4282129ef427fe68cff0719de0d25f8905a1ce23884cb5c5f9b64ed2206de58dd76902b0031a7a6d7a200123800c3dd0fb19822eda14ea6c21ed37f280a1e456", SHA512, "test")
+	assert.NoError(t, err)
+
+	err = digests.Check("bad", SHA256, "test")
+	assert.EqualError(t, err, "Invalid digest: 0c15e883dee85bb2f3540a47ec58f617a2547117f9096417ba5422268029f501 != bad (test)")
+	err = digests.Check("", SHA256, "test")
+	assert.EqualError(t, err, "Missing digest")
+	err = NewDigestWriter(SHA256).Check("bad", SHA512, "test")
+	assert.EqualError(t, err, "invalid digest type: sha512")
+}
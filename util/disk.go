@@ -0,0 +1,65 @@
+package util
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// checkFreeSpace returns an error if there isn't enough free space to write
+// size bytes to path (if we can tell).
+func checkFreeSpace(path string, size int64) error {
+	if size <= 0 {
+		return nil
+	}
+	// Check the nearest directory that exists
+	dir := filepath.Dir(path)
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil
+		}
+		dir = parent
+	}
+	free, ok, err := freeSpace(dir)
+	if err != nil {
+		logger.Warningf("Error checking free space for %s: %v", dir, err)
+		return nil
+	}
+	if !ok {
+		return nil
+	}
+	if free < size {
+		return fmt.Errorf("Not enough free space in %s (need %d bytes, %d available)", dir, size, free)
+	}
+	return nil
+}
+
+// sizeError is the error if a download exceeds the expected size.
+type sizeError struct {
+	size int64
+}
+
+func (e sizeError) Error() string {
+	return fmt.Sprintf("Download exceeded expected size (%d)", e.size)
+}
+
+// sizeLimitReader returns a sizeError if more than remaining bytes are read.
+type sizeLimitReader struct {
+	r         io.Reader
+	size      int64
+	remaining int64
+}
+
+func (r *sizeLimitReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.remaining -= int64(n)
+	if r.remaining < 0 {
+		return n, sizeError{size: r.size}
+	}
+	return n, err
+}
@@ -0,0 +1,15 @@
+package util
+
+import (
+	"syscall"
+)
+
+// freeSpace returns the free space (available to us) on the filesystem for
+// path.
+func freeSpace(path string) (int64, bool, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, false, err
+	}
+	return int64(st.Bavail) * int64(st.Bsize), true, nil
+}
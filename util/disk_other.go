@@ -0,0 +1,8 @@
+// +build !linux
+
+package util
+
+// freeSpace isn't supported on this platform.
+func freeSpace(path string) (int64, bool, error) {
+	return 0, false, nil
+}
@@ -0,0 +1,23 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckFreeSpace(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "TestCheckFreeSpace", "subdir", "file")
+	err := checkFreeSpace(path, 1)
+	require.NoError(t, err)
+
+	err = checkFreeSpace(path, 1<<62)
+	if runtime.GOOS == "linux" {
+		require.Error(t, err)
+	} else {
+		require.NoError(t, err)
+	}
+}
@@ -0,0 +1,80 @@
+package util
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// DownloadInfo is saved beside a download (see DownloadInfoPath), with the
+// validators (ETag, Last-Modified) from the server, so we can check if it
+// changed with a conditional request, or resume it with a Range request.
+type DownloadInfo struct {
+	URL          string     `json:"url"`
+	ETag         string     `json:"etag,omitempty"`
+	LastModified string     `json:"lastModified,omitempty"`
+	Digest       string     `json:"digest,omitempty"`
+	DigestType   DigestType `json:"digestType,omitempty"`
+}
+
+// DownloadInfoPath is the path for the info for a download at path.
+func DownloadInfoPath(path string) string {
+	return path + ".json"
+}
+
+// LoadDownloadInfo loads the info for a download at path.
+// If there is no info, returns nil.
+func LoadDownloadInfo(path string) (*DownloadInfo, error) {
+	b, err := ReadFile(DownloadInfoPath(path))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var info DownloadInfo
+	if err := json.Unmarshal(b, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// Save the info for a download at path.
+func (i DownloadInfo) Save(path string) error {
+	b, err := json.Marshal(i)
+	if err != nil {
+		return err
+	}
+	return NewFile(DownloadInfoPath(path), b, 0600).Save()
+}
+
+// newDownloadInfo returns the info for a download from the response.
+func newDownloadInfo(urlString string, resp *http.Response) DownloadInfo {
+	return DownloadInfo{
+		URL:          urlString,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+}
+
+// setConditional sets the headers so the request returns 304 (Not Modified)
+// if the download hasn't changed.
+func (i DownloadInfo) setConditional(req *http.Request) bool {
+	if i.ETag != "" {
+		req.Header.Set("If-None-Match", i.ETag)
+	}
+	if i.LastModified != "" {
+		req.Header.Set("If-Modified-Since", i.LastModified)
+	}
+	return i.ETag != "" || i.LastModified != ""
+}
+
+// validator returns the If-Range value for resuming the download.
+// Weak ETags can't be used with If-Range, so we fall back to Last-Modified.
+func (i DownloadInfo) validator() string {
+	if i.ETag != "" && !strings.HasPrefix(i.ETag, "W/") {
+		return i.ETag
+	}
+	return i.LastModified
+}
@@ -11,6 +11,9 @@ import (
 )
 
 // ComputeEtag returns etag for a file at path
+//
+// Deprecated: Downloads are revalidated with the server's ETag (and
+// Last-Modified), saved in the DownloadInfo, see DownloadURLOptions.UseETag.
 func ComputeEtag(path string) (string, error) {
 	var result []byte
 	file, err := os.Open(path)
@@ -0,0 +1,266 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package util
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+	"github.com/ulikunitz/xz"
+)
+
+// ArchiveFormat is an archive format supported by Extract.
+type ArchiveFormat string
+
+const (
+	// Zip archive (.zip)
+	Zip ArchiveFormat = "zip"
+	// Tar archive (.tar)
+	Tar ArchiveFormat = "tar"
+	// TarGzip is a gzip compressed tar archive (.tar.gz, .tgz)
+	TarGzip ArchiveFormat = "tar.gz"
+	// TarBzip2 is a bzip2 compressed tar archive (.tar.bz2, .tbz2)
+	TarBzip2 ArchiveFormat = "tar.bz2"
+	// TarXz is a xz compressed tar archive (.tar.xz, .txz)
+	TarXz ArchiveFormat = "tar.xz"
+	// TarZstd is a zstd compressed tar archive (.tar.zst, .tzst)
+	TarZstd ArchiveFormat = "tar.zst"
+)
+
+var archiveExtensions = []struct {
+	ext    string
+	format ArchiveFormat
+}{
+	{".zip", Zip},
+	{".tar", Tar},
+	{".tar.gz", TarGzip},
+	{".tgz", TarGzip},
+	{".tar.bz2", TarBzip2},
+	{".tbz2", TarBzip2},
+	{".tar.xz", TarXz},
+	{".txz", TarXz},
+	{".tar.zst", TarZstd},
+	{".tzst", TarZstd},
+}
+
+// ArchiveFormatForName returns the archive format from a file name (by
+// extension), or "" if it isn't a supported archive.
+func ArchiveFormatForName(name string) ArchiveFormat {
+	name = strings.ToLower(name)
+	for _, e := range archiveExtensions {
+		if strings.HasSuffix(name, e.ext) {
+			return e.format
+		}
+	}
+	return ""
+}
+
+// DetectArchiveFormat returns the archive format for a file, from its name, or
+// if the name doesn't have a supported extension, from its magic bytes.
+// Returns "" if it isn't a supported archive.
+func DetectArchiveFormat(path string) (ArchiveFormat, error) {
+	if format := ArchiveFormatForName(filepath.Base(path)); format != "" {
+		return format, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer Close(f)
+	header := make([]byte, 512)
+	n, err := io.ReadFull(f, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	return archiveFormatForHeader(header[:n]), nil
+}
+
+func archiveFormatForHeader(header []byte) ArchiveFormat {
+	switch {
+	case bytes.HasPrefix(header, []byte("PK\x03\x04")), bytes.HasPrefix(header, []byte("PK\x05\x06")):
+		return Zip
+	case bytes.HasPrefix(header, []byte{0x1f, 0x8b}):
+		return TarGzip
+	case bytes.HasPrefix(header, []byte("BZh")):
+		return TarBzip2
+	case bytes.HasPrefix(header, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}):
+		return TarXz
+	case bytes.HasPrefix(header, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return TarZstd
+	case len(header) >= 262 && bytes.Equal(header[257:262], []byte("ustar")):
+		return Tar
+	default:
+		return ""
+	}
+}
+
+// Extract safely extracts an archive and moves path (in the archive) to a
+// destination, like UnzipOver, for any supported ArchiveFormat.
+// If destination path exists, it will be removed first.
+// You can specify a check function, which will run before moving the extracted
+// path into place.
+// If you specify a tmpDir and destination path exists, it will be moved there
+// instead of being removed.
+func Extract(sourcePath string, path string, destinationPath string, check func(sourcePath, destinationPath string) error, tmpDir string) error {
+	extractPath, err := ExtractPath(sourcePath)
+	defer RemoveFileAtPath(extractPath)
+	if err != nil {
+		return err
+	}
+
+	contentPath := filepath.Join(extractPath, path)
+
+	err = check(contentPath, destinationPath)
+	if err != nil {
+		return err
+	}
+
+	return MoveFile(contentPath, destinationPath, tmpDir)
+}
+
+// ExtractPath extracts an archive and returns the path to the extracted
+// directory.
+func ExtractPath(sourcePath string) (string, error) {
+	extractPath := fmt.Sprintf("%s.extracted", sourcePath)
+	if _, ferr := os.Stat(extractPath); ferr == nil {
+		logger.Infof("Removing existing extract destination path: %s", extractPath)
+		if err := os.RemoveAll(extractPath); err != nil {
+			return extractPath, err
+		}
+	}
+	logger.Infof("Extracting %q to %q", sourcePath, extractPath)
+	_, err := ExtractWithOptions(sourcePath, extractPath, UnzipOptions{Limits: DefaultArchiveLimits})
+	return extractPath, err
+}
+
+// ExtractWithOptions extracts an archive (see ArchiveFormat) to a destination
+// with options, and returns a manifest of the extracted files.
+// Archives have the same safety checks as Unzip.
+func ExtractWithOptions(sourcePath string, destinationPath string, options UnzipOptions) ([]ArchiveEntry, error) {
+	format, err := DetectArchiveFormat(sourcePath)
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case Zip:
+		return UnzipWithOptions(sourcePath, destinationPath, options)
+	case "":
+		return nil, errors.Errorf("Unsupported archive %s", filepath.Base(sourcePath))
+	default:
+		return untarWithOptions(sourcePath, format, destinationPath, options)
+	}
+}
+
+// countReader counts bytes read.
+type countReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	r.n += int64(n)
+	return n, err
+}
+
+func decompressReader(r io.Reader, format ArchiveFormat) (io.ReadCloser, error) {
+	switch format {
+	case Tar:
+		return ioutil.NopCloser(r), nil
+	case TarGzip:
+		return gzip.NewReader(r)
+	case TarBzip2:
+		return ioutil.NopCloser(bzip2.NewReader(r)), nil
+	case TarXz:
+		xr, err := xz.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.NopCloser(xr), nil
+	case TarZstd:
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	default:
+		return nil, errors.Errorf("Unsupported archive format %s", format)
+	}
+}
+
+func untarWithOptions(sourcePath string, format ArchiveFormat, destinationPath string, options UnzipOptions) ([]ArchiveEntry, error) {
+	f, err := os.Open(sourcePath)
+	if err != nil {
+		return nil, err
+	}
+	defer Close(f)
+
+	compressed := &countReader{r: bufio.NewReader(f)}
+	dr, err := decompressReader(compressed, format)
+	if err != nil {
+		return nil, err
+	}
+	defer Close(dr)
+
+	x, err := newArchiveExtractor(destinationPath, options)
+	if err != nil {
+		return nil, err
+	}
+
+	limits := options.Limits
+	total := int64(0)
+	tr := tar.NewReader(dr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		total += header.Size
+		if limits.MaxSize > 0 && total > limits.MaxSize {
+			return nil, ArchiveError{Reason: fmt.Sprintf("total size exceeds %d", limits.MaxSize)}
+		}
+		fileInfo := header.FileInfo()
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = x.dir(header.Name, fileInfo.Mode(), header.ModTime)
+		case tar.TypeReg:
+			err = x.file(header.Name, fileInfo.Mode(), header.ModTime, tr)
+		case tar.TypeSymlink:
+			err = x.symlink(header.Name, header.Linkname)
+		case tar.TypeLink:
+			err = x.link(header.Name, header.Linkname)
+		case tar.TypeXGlobalHeader:
+			continue
+		default:
+			logger.Warningf("Skipping unsupported archive entry %q (type %c)", header.Name, header.Typeflag)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		// Check the compression ratio (of what we extracted so far)
+		if format != Tar {
+			if err := limits.checkRatio(header.Name, compressed.n, total); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return x.finish()
+}
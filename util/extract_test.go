@@ -0,0 +1,148 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package util
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestArchiveFormatForName(t *testing.T) {
+	require.Equal(t, Zip, ArchiveFormatForName("Keys-0.0.18.zip"))
+	require.Equal(t, TarGzip, ArchiveFormatForName("keys-0.0.18.tar.gz"))
+	require.Equal(t, TarGzip, ArchiveFormatForName("keys-0.0.18.TGZ"))
+	require.Equal(t, TarBzip2, ArchiveFormatForName("keys-0.0.18.tar.bz2"))
+	require.Equal(t, TarXz, ArchiveFormatForName("keys-0.0.18.tar.xz"))
+	require.Equal(t, TarZstd, ArchiveFormatForName("keys-0.0.18.tar.zst"))
+	require.Equal(t, Tar, ArchiveFormatForName("keys-0.0.18.tar"))
+	require.Equal(t, ArchiveFormat(""), ArchiveFormatForName("Keys-0.0.18.AppImage"))
+}
+
+func TestExtract(t *testing.T) {
+	for _, path := range []string{testZipPath, "../test/test.tar.gz", "../test/test.tar.bz2", "../test/test.tar.xz", "../test/test.tar.zst"} {
+		t.Logf("Extract %s", path)
+		testExtractValid(t, path)
+	}
+}
+
+func testExtractValid(t *testing.T, path string) {
+	destinationPath := TempPath("", "TestExtract.")
+	defer RemoveFileAtPath(destinationPath)
+
+	noCheck := func(sourcePath, destinationPath string) error { return nil }
+
+	// Copy to a path without an extension, to detect by magic bytes
+	sourcePath := TempPath("", "TestExtract.")
+	defer RemoveFileAtPath(sourcePath)
+	err := CopyFile(path, sourcePath)
+	require.NoError(t, err)
+	format, err := DetectArchiveFormat(sourcePath)
+	require.NoError(t, err)
+	require.Equal(t, ArchiveFormatForName(path), format)
+
+	err = Extract(sourcePath, "test", destinationPath, noCheck, "")
+	require.NoError(t, err)
+
+	assertFileExists(t, filepath.Join(destinationPath, "testfile"))
+	assertFileExists(t, filepath.Join(destinationPath, "testfolder", "testsubfolder", "testfile2"))
+	exists, err := FileExists(sourcePath + ".extracted")
+	require.NoError(t, err)
+	require.False(t, exists)
+}
+
+func TestExtractUnsupported(t *testing.T) {
+	_, err := ExtractWithOptions("../test/testfile", TempPath("", "TestExtractUnsupported."), UnzipOptions{})
+	require.EqualError(t, err, "Unsupported archive testfile")
+}
+
+type testTarEntry struct {
+	name     string
+	typeflag byte
+	linkname string
+	data     []byte
+}
+
+func testTarGz(t *testing.T, entries []testTarEntry) string {
+	path := TempPath("", "TestExtract.") + ".tar.gz"
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer Close(f)
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+	for _, entry := range entries {
+		header := &tar.Header{
+			Name:     entry.name,
+			Typeflag: entry.typeflag,
+			Linkname: entry.linkname,
+			Mode:     0644,
+			Size:     int64(len(entry.data)),
+		}
+		err := tw.WriteHeader(header)
+		require.NoError(t, err)
+		_, err = tw.Write(entry.data)
+		require.NoError(t, err)
+	}
+	err = tw.Close()
+	require.NoError(t, err)
+	err = gw.Close()
+	require.NoError(t, err)
+	return path
+}
+
+func testExtractUnsafe(t *testing.T, entries []testTarEntry, limits ArchiveLimits) error {
+	path := testTarGz(t, entries)
+	defer RemoveFileAtPath(path)
+	destinationPath := TempPath("", "TestExtract.")
+	defer RemoveFileAtPath(destinationPath)
+	_, err := ExtractWithOptions(path, destinationPath, UnzipOptions{Limits: limits})
+	return err
+}
+
+func TestExtractUnsafe(t *testing.T) {
+	err := testExtractUnsafe(t, []testTarEntry{{name: "../evil", typeflag: tar.TypeReg, data: []byte("evil")}}, DefaultArchiveLimits)
+	require.EqualError(t, err, `Unsafe archive entry "../evil": path outside of destination`)
+
+	err = testExtractUnsafe(t, []testTarEntry{{name: "/evil", typeflag: tar.TypeReg, data: []byte("evil")}}, DefaultArchiveLimits)
+	require.EqualError(t, err, `Unsafe archive entry "/evil": absolute path`)
+
+	err = testExtractUnsafe(t, []testTarEntry{{name: "link", typeflag: tar.TypeSymlink, linkname: "../../etc"}}, DefaultArchiveLimits)
+	require.EqualError(t, err, `Unsafe archive entry "link": symlink outside of destination`)
+
+	err = testExtractUnsafe(t, []testTarEntry{{name: "link", typeflag: tar.TypeLink, linkname: "../../etc/passwd"}}, DefaultArchiveLimits)
+	require.EqualError(t, err, `Unsafe archive entry "link": link outside of destination`)
+
+	err = testExtractUnsafe(t, []testTarEntry{
+		{name: "dir", typeflag: tar.TypeDir},
+		{name: "link", typeflag: tar.TypeSymlink, linkname: "dir"},
+		{name: "link/file", typeflag: tar.TypeReg, data: []byte("evil")},
+	}, DefaultArchiveLimits)
+	require.EqualError(t, err, `Unsafe archive entry "link/file": path through symlink`)
+
+	err = testExtractUnsafe(t, []testTarEntry{
+		{name: "a", typeflag: tar.TypeReg, data: []byte("a")},
+		{name: "b", typeflag: tar.TypeReg, data: []byte("b")},
+	}, ArchiveLimits{MaxEntries: 1})
+	require.EqualError(t, err, `Unsafe archive: too many entries (2 > 1)`)
+
+	err = testExtractUnsafe(t, []testTarEntry{
+		{name: "a", typeflag: tar.TypeReg, data: []byte("aaaa")},
+		{name: "b", typeflag: tar.TypeReg, data: []byte("bbbb")},
+	}, ArchiveLimits{MaxSize: 6})
+	require.EqualError(t, err, `Unsafe archive: total size exceeds 6`)
+
+	zeros := make([]byte, 2*minRatioSize)
+	err = testExtractUnsafe(t, []testTarEntry{{name: "zeros", typeflag: tar.TypeReg, data: zeros}}, DefaultArchiveLimits)
+	require.EqualError(t, err, `Unsafe archive entry "zeros": compression ratio exceeds 200`)
+
+	err = testExtractUnsafe(t, []testTarEntry{
+		{name: "file", typeflag: tar.TypeReg, data: []byte("ok")},
+		{name: "link", typeflag: tar.TypeLink, linkname: "file"},
+	}, DefaultArchiveLimits)
+	require.NoError(t, err)
+}
@@ -4,6 +4,7 @@
 package util
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -48,19 +49,40 @@ func DiscardAndCloseBody(resp *http.Response) error {
 
 // SaveHTTPResponse saves an http.Response to path
 func SaveHTTPResponse(resp *http.Response, savePath string, mode os.FileMode) error {
+	return saveHTTPResponse(resp, savePath, mode, os.O_WRONLY|os.O_CREATE|os.O_EXCL, nil, nil)
+}
+
+// appendHTTPResponse appends an http.Response to an existing file at path
+func appendHTTPResponse(resp *http.Response, savePath string, progress *progressWriter, digests *DigestWriter) error {
+	return saveHTTPResponse(resp, savePath, 0, os.O_WRONLY|os.O_APPEND, progress, digests)
+}
+
+// saveHTTPResponse saves a response, with progress and digests (if not nil).
+func saveHTTPResponse(resp *http.Response, savePath string, mode os.FileMode, flag int, progress *progressWriter, digests *DigestWriter) error {
 	if resp == nil {
 		return fmt.Errorf("No response")
 	}
-	file, err := os.OpenFile(savePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	file, err := os.OpenFile(savePath, flag, mode)
 	if err != nil {
 		return err
 	}
 	defer Close(file)
 
 	logger.Infof("Downloading to %s", savePath)
-	n, err := io.Copy(file, resp.Body)
+	var body io.Reader = resp.Body
+	if progress != nil {
+		body = io.TeeReader(body, progress)
+	}
+	var w io.Writer = file
+	if digests != nil {
+		w = io.MultiWriter(file, digests)
+	}
+	n, err := io.Copy(w, body)
 	if err == nil {
 		logger.Infof("Downloaded %d bytes", n)
+		if progress != nil {
+			progress.finish()
+		}
 	}
 	return err
 }
@@ -125,17 +147,47 @@ type DownloadURLOptions struct {
 	Digest     string
 	SkipDigest bool
 	DigestType DigestType
-	UseETag    bool
-	Timeout    time.Duration
+	// UseETag checks if an existing download changed, with a conditional
+	// request using the validators (ETag, Last-Modified) saved when it was
+	// downloaded, see DownloadInfo.
+	UseETag bool
+	Timeout time.Duration
+	// Size is the expected size (if set), checked against the response
+	// Content-Length, and used for progress if the response doesn't have one.
+	Size int64
+	// Progress is called with download progress (if set).
+	Progress ProgressFn
+	// Retry is the policy for retrying a failed download (by default, we
+	// don't retry).
+	// A partial download is resumed (if possible) when we retry.
+	Retry RetryPolicy
 }
 
 // DownloadURL downloads a URL to a path.
 func DownloadURL(urlString string, destinationPath string, options DownloadURLOptions) error {
-	_, err := downloadURL(urlString, destinationPath, options)
+	return DownloadURLContext(context.Background(), urlString, destinationPath, options)
+}
+
+// DownloadURLContext downloads a URL to a path, retrying (see
+// DownloadURLOptions.Retry).
+// If the context is canceled, the request is stopped and the partial download
+// is removed.
+func DownloadURLContext(ctx context.Context, urlString string, destinationPath string, options DownloadURLOptions) error {
+	err := options.Retry.Do(ctx, func() error {
+		_, err := downloadURL(ctx, urlString, destinationPath, options)
+		return err
+	})
+	if err != nil && ctx.Err() != nil {
+		// Canceled (during a request, or waiting to retry)
+		savePath := fmt.Sprintf("%s.download", destinationPath)
+		logger.Infof("Removing partial download: %s", savePath)
+		removePartialDownload(savePath)
+		return ctx.Err()
+	}
 	return err
 }
 
-func downloadURL(urlString string, destinationPath string, options DownloadURLOptions) (cached bool, _ error) {
+func downloadURL(ctx context.Context, urlString string, destinationPath string, options DownloadURLOptions) (cached bool, _ error) {
 	url, err := parseURL(urlString)
 	if err != nil {
 		return false, err
@@ -143,29 +195,36 @@ func downloadURL(urlString string, destinationPath string, options DownloadURLOp
 
 	// Handle local files
 	if url.Scheme == fileScheme {
-		return cached, downloadLocal(PathFromURL(url), destinationPath, options)
-	}
-
-	// Compute ETag if the destinationPath already exists
-	etag := ""
-	if options.UseETag {
-		if _, statErr := os.Stat(destinationPath); statErr == nil {
-			computedEtag, etagErr := ComputeEtag(destinationPath)
-			if etagErr != nil {
-				logger.Warningf("Error computing etag", etagErr)
-			} else {
-				etag = computedEtag
-			}
+		if err := ctx.Err(); err != nil {
+			return cached, err
 		}
+		return cached, downloadLocal(url.String(), PathFromURL(url), destinationPath, options)
 	}
 
-	req, err := http.NewRequest("GET", url.String(), nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url.String(), nil)
 	if err != nil {
 		return cached, err
 	}
-	if etag != "" {
-		logger.Infof("Using etag: %s", etag)
-		req.Header.Set("If-None-Match", etag)
+
+	// If we already downloaded it, check if it changed
+	if options.UseETag {
+		if info := existingDownloadInfo(url.String(), destinationPath); info != nil && info.setConditional(req) {
+			logger.Infof("Using validators: %s %s", info.ETag, info.LastModified)
+		}
+	}
+
+	// Resume partial download (if we have one)
+	savePath := fmt.Sprintf("%s.download", destinationPath)
+	offset, validator := resumePartialDownload(url.String(), savePath)
+	if offset > 0 {
+		logger.Infof("Resuming partial download at %d: %s", offset, savePath)
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", validator)
+	}
+	if options.Size > 0 {
+		if err := checkFreeSpace(savePath, options.Size-offset); err != nil {
+			return cached, err
+		}
 	}
 	var client http.Client
 	if options.Timeout > 0 {
@@ -176,6 +235,9 @@ func downloadURL(urlString string, destinationPath string, options DownloadURLOp
 	logger.Infof("Request %s", url.String())
 	resp, requestErr := client.Do(req)
 	if requestErr != nil {
+		if ctx.Err() != nil {
+			return cached, ctx.Err()
+		}
 		return cached, requestErr
 	}
 	if resp == nil {
@@ -192,34 +254,98 @@ func downloadURL(urlString string, destinationPath string, options DownloadURLOp
 				if rerr := os.Remove(destinationPath); rerr != nil {
 					return cached, fmt.Errorf("Error removing existing download: %s", rerr)
 				}
+				RemoveFileAtPath(DownloadInfoPath(destinationPath))
 				return cached, err
 			}
 		}
 
 		return cached, nil
 	}
-	if resp.StatusCode != http.StatusOK {
-		return cached, fmt.Errorf("%s", resp.Status)
+	switch resp.StatusCode {
+	case http.StatusOK:
+		offset = 0
+	case http.StatusPartialContent:
+		start, err := contentRangeStart(resp)
+		if err != nil || offset == 0 || start != offset {
+			removePartialDownload(savePath)
+			return cached, fmt.Errorf("Invalid partial response (%d): %v", offset, err)
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		if offset == 0 {
+			return cached, fmt.Errorf("%s", resp.Status)
+		}
+		// Partial download is invalid, start over
+		logger.Infof("Unable to resume partial download, removing %s", savePath)
+		removePartialDownload(savePath)
+		DiscardAndCloseBodyIgnoreError(resp)
+		return downloadURL(ctx, urlString, destinationPath, options)
+	default:
+		return cached, NewHTTPStatusError(resp)
 	}
 
-	savePath := fmt.Sprintf("%s.download", destinationPath)
-	if _, ferr := os.Stat(savePath); ferr == nil {
-		logger.Infof("Removing existing partial download: %s", savePath)
-		if rerr := os.Remove(savePath); rerr != nil {
-			return cached, fmt.Errorf("Error removing existing partial download: %s", rerr)
-		}
+	if options.Size > 0 && resp.ContentLength >= 0 && offset+resp.ContentLength != options.Size {
+		removePartialDownload(savePath)
+		return cached, fmt.Errorf("Content length (%d) doesn't match expected size (%d)", offset+resp.ContentLength, options.Size)
 	}
 
-	if err := MakeParentDirs(savePath, 0700); err != nil {
-		return cached, err
+	if options.Size > 0 {
+		// Stop if we get more than we expect
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{&sizeLimitReader{r: resp.Body, size: options.Size, remaining: options.Size - offset}, resp.Body}
 	}
 
-	if err := SaveHTTPResponse(resp, savePath, 0600); err != nil {
-		return cached, err
+	var progress *progressWriter
+	if options.Progress != nil {
+		total := options.Size
+		if resp.ContentLength > 0 {
+			total = offset + resp.ContentLength
+		}
+		progress = newProgressWriter(options.Progress, offset, total)
 	}
 
+	// Compute the digest as we save (including the partial download if we are
+	// resuming), so we don't have to read the file again.
+	var digests *DigestWriter
 	if !options.SkipDigest {
-		if err := CheckDigest(options.Digest, savePath, options.DigestType); err != nil {
+		digests = NewDigestWriter(options.DigestType)
+		if offset > 0 {
+			if err := digestFile(savePath, offset, digests); err != nil {
+				removePartialDownload(savePath)
+				return cached, err
+			}
+		}
+	}
+
+	if offset == 0 {
+		if _, ferr := os.Stat(savePath); ferr == nil {
+			logger.Infof("Removing existing partial download: %s", savePath)
+			if rerr := os.Remove(savePath); rerr != nil {
+				return cached, fmt.Errorf("Error removing existing partial download: %s", rerr)
+			}
+		}
+
+		if err := MakeParentDirs(savePath, 0700); err != nil {
+			return cached, err
+		}
+
+		if err := savePartialDownload(url.String(), savePath, resp); err != nil {
+			logger.Warningf("Error saving partial download info: %s", err)
+		}
+
+		if err := saveHTTPResponse(resp, savePath, 0600, os.O_WRONLY|os.O_CREATE|os.O_EXCL, progress, digests); err != nil {
+			return cached, saveError(ctx, savePath, err)
+		}
+	} else {
+		if err := appendHTTPResponse(resp, savePath, progress, digests); err != nil {
+			return cached, saveError(ctx, savePath, err)
+		}
+	}
+
+	if digests != nil {
+		if err := digests.Check(options.Digest, options.DigestType, savePath); err != nil {
+			removePartialDownload(savePath)
 			return cached, err
 		}
 	}
@@ -227,11 +353,86 @@ func downloadURL(urlString string, destinationPath string, options DownloadURLOp
 	if err := MoveFile(savePath, destinationPath, ""); err != nil {
 		return cached, err
 	}
+	removePartialDownload(savePath)
+
+	info := newDownloadInfo(url.String(), resp)
+	if !options.SkipDigest {
+		info.Digest, info.DigestType = options.Digest, options.DigestType
+	}
+	saveDownloadInfo(info, destinationPath)
 
 	return cached, nil
 }
 
-func downloadLocal(localPath string, destinationPath string, options DownloadURLOptions) error {
+// existingDownloadInfo returns the info for an existing download (of the same
+// URL) at path, or nil.
+func existingDownloadInfo(urlString string, path string) *DownloadInfo {
+	if exists, err := FileExists(path); err != nil || !exists {
+		return nil
+	}
+	info, err := LoadDownloadInfo(path)
+	if err != nil {
+		logger.Warningf("Invalid download info: %s", err)
+		return nil
+	}
+	if info == nil || info.URL != urlString {
+		return nil
+	}
+	return info
+}
+
+// saveDownloadInfo saves info for a download, logging if it fails (the
+// download is ok, we just won't be able to check if it changed).
+func saveDownloadInfo(info DownloadInfo, path string) {
+	if err := info.Save(path); err != nil {
+		logger.Warningf("Error saving download info: %s", err)
+	}
+}
+
+// digestFile writes the first n bytes of the file at path to digests.
+func digestFile(path string, n int64, digests *DigestWriter) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer Close(f)
+	if _, err := io.CopyN(digests, f, n); err != nil {
+		return err
+	}
+	return nil
+}
+
+// saveError handles an error saving a response.
+// If canceled (or the download was too big), the partial download is removed,
+// otherwise it is kept so it can be resumed.
+func saveError(ctx context.Context, savePath string, err error) error {
+	if _, ok := err.(sizeError); ok {
+		logger.Infof("Removing partial download: %s", savePath)
+		removePartialDownload(savePath)
+		return err
+	}
+	if ctx.Err() != nil {
+		logger.Infof("Removing partial download: %s", savePath)
+		removePartialDownload(savePath)
+		return ctx.Err()
+	}
+	logger.Infof("Keeping partial download: %s", savePath)
+	return err
+}
+
+func downloadLocal(urlString string, localPath string, destinationPath string, options DownloadURLOptions) error {
+	if options.Size > 0 {
+		fi, err := os.Stat(localPath)
+		if err != nil {
+			return err
+		}
+		if fi.Size() != options.Size {
+			return fmt.Errorf("File size (%d) doesn't match expected size (%d)", fi.Size(), options.Size)
+		}
+		if err := checkFreeSpace(destinationPath, options.Size); err != nil {
+			return err
+		}
+	}
 	if err := CopyFile(localPath, destinationPath); err != nil {
 		return err
 	}
@@ -241,6 +442,11 @@ func downloadLocal(localPath string, destinationPath string, options DownloadURL
 			return err
 		}
 	}
+	info := DownloadInfo{URL: urlString}
+	if !options.SkipDigest {
+		info.Digest, info.DigestType = options.Digest, options.DigestType
+	}
+	saveDownloadInfo(info, destinationPath)
 	return nil
 }
 
@@ -5,6 +5,8 @@ package util
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -45,6 +47,9 @@ func testServerWithETag(t *testing.T, data string, delay time.Duration, etag str
 			}
 		}
 
+		if etag != "" {
+			w.Header().Set("ETag", etag)
+		}
 		w.Header().Set("Content-Type", "application/json")
 		fmt.Fprintln(w, data)
 	}))
@@ -226,17 +231,58 @@ func TestDownloadURLLocal(t *testing.T) {
 
 func TestDownloadURLETag(t *testing.T) {
 	data := []byte("ok\n")
-	etag := "eff5bc1ef8ec9d03e640fc4370f5eacd"
+	etag := `"5b8d2c5a-3"`
 	server := testServerWithETag(t, "ok", 0, etag)
 	defer server.Close()
 	destinationPath := TempPath("", "TestDownloadURLETag.")
-	err := ioutil.WriteFile(destinationPath, data, 0600)
+	defer RemoveFileAtPath(destinationPath)
+	defer RemoveFileAtPath(DownloadInfoPath(destinationPath))
+	digest, err := Digest256(bytes.NewReader(data))
+	require.NoError(t, err)
+
+	// Existing file (without info) is downloaded again
+	err = ioutil.WriteFile(destinationPath, data, 0600)
+	require.NoError(t, err)
+	cached, err := downloadURL(context.TODO(), server.URL, destinationPath, DownloadURLOptions{Digest: digest, UseETag: true})
+	require.NoError(t, err)
+	require.False(t, cached)
+	info, err := LoadDownloadInfo(destinationPath)
+	require.NoError(t, err)
+	require.Equal(t, &DownloadInfo{URL: server.URL, ETag: etag, Digest: digest}, info)
+
+	cached, err = downloadURL(context.TODO(), server.URL, destinationPath, DownloadURLOptions{Digest: digest, UseETag: true})
 	require.NoError(t, err)
+	require.True(t, cached)
+
+	// Different URL
+	cached, err = downloadURL(context.TODO(), server.URL+"/other", destinationPath, DownloadURLOptions{Digest: digest, UseETag: true})
+	require.NoError(t, err)
+	require.False(t, cached)
+}
+
+func TestDownloadURLLastModified(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 100)
 	digest, err := Digest256(bytes.NewReader(data))
-	assert.NoError(t, err)
-	cached, err := downloadURL(server.URL, destinationPath, DownloadURLOptions{Digest: digest, UseETag: true})
 	require.NoError(t, err)
-	assert.True(t, cached)
+	modTime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "", modTime, bytes.NewReader(data))
+	}))
+	defer server.Close()
+	destinationPath := TempPath("", "TestDownloadURLLastModified.")
+	defer RemoveFileAtPath(destinationPath)
+	defer RemoveFileAtPath(DownloadInfoPath(destinationPath))
+
+	cached, err := downloadURL(context.TODO(), server.URL, destinationPath, DownloadURLOptions{Digest: digest, UseETag: true})
+	require.NoError(t, err)
+	require.False(t, cached)
+	info, err := LoadDownloadInfo(destinationPath)
+	require.NoError(t, err)
+	require.Equal(t, "Wed, 01 Jan 2020 00:00:00 GMT", info.LastModified)
+
+	cached, err = downloadURL(context.TODO(), server.URL, destinationPath, DownloadURLOptions{Digest: digest, UseETag: true})
+	require.NoError(t, err)
+	require.True(t, cached)
 }
 
 func TestURLExistsParseError(t *testing.T) {
@@ -258,3 +304,253 @@ func TestURLValueForBool(t *testing.T) {
 	assert.Equal(t, "0", URLValueForBool(false))
 	assert.Equal(t, "1", URLValueForBool(true))
 }
+
+func TestDownloadURLContextCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "1024")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, "partial")
+		w.(http.Flusher).Flush()
+		cancel()
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	destinationPath := TempPath("", "TestDownloadURLContextCancel.")
+	defer RemoveFileAtPath(destinationPath)
+	err := DownloadURLContext(ctx, server.URL, destinationPath, DownloadURLOptions{SkipDigest: true})
+	require.Equal(t, context.Canceled, err)
+
+	exists, err := FileExists(destinationPath + ".download")
+	require.NoError(t, err)
+	require.False(t, exists)
+	exists, err = FileExists(destinationPath)
+	require.NoError(t, err)
+	require.False(t, exists)
+}
+
+func TestDownloadURLContextCancelRequest(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Cancel before responding
+		cancel()
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	destinationPath := TempPath("", "TestDownloadURLContextCancelRequest.")
+	defer RemoveFileAtPath(destinationPath)
+	savePath := destinationPath + ".download"
+
+	// Partial download (from before)
+	err := ioutil.WriteFile(savePath, []byte("partial"), 0600)
+	require.NoError(t, err)
+	err = DownloadInfo{URL: server.URL, ETag: `"v1"`}.Save(savePath)
+	require.NoError(t, err)
+
+	err = DownloadURLContext(ctx, server.URL, destinationPath, DownloadURLOptions{SkipDigest: true})
+	require.Equal(t, context.Canceled, err)
+	exists, err := FileExists(savePath)
+	require.NoError(t, err)
+	require.False(t, exists)
+	exists, err = FileExists(DownloadInfoPath(savePath))
+	require.NoError(t, err)
+	require.False(t, exists)
+}
+
+func testServerForContent(t *testing.T, data []byte, etag string, ranges *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*ranges = append(*ranges, r.Header.Get("Range"))
+		w.Header().Set("ETag", etag)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+}
+
+func TestDownloadURLResume(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 100)
+	digest, err := Digest256(bytes.NewReader(data))
+	require.NoError(t, err)
+	var ranges []string
+	server := testServerForContent(t, data, `"v1"`, &ranges)
+	defer server.Close()
+
+	destinationPath := TempPath("", "TestDownloadURLResume.")
+	defer RemoveFileAtPath(destinationPath)
+	savePath := destinationPath + ".download"
+
+	// Partial download
+	err = ioutil.WriteFile(savePath, data[:300], 0600)
+	require.NoError(t, err)
+	p := DownloadInfo{URL: server.URL, ETag: `"v1"`}
+	b, err := json.Marshal(p)
+	require.NoError(t, err)
+	err = ioutil.WriteFile(DownloadInfoPath(savePath), b, 0600)
+	require.NoError(t, err)
+
+	err = DownloadURL(server.URL, destinationPath, DownloadURLOptions{Digest: digest})
+	require.NoError(t, err)
+	require.Equal(t, []string{"bytes=300-"}, ranges)
+	out, err := ioutil.ReadFile(destinationPath)
+	require.NoError(t, err)
+	require.Equal(t, data, out)
+	exists, err := FileExists(DownloadInfoPath(savePath))
+	require.NoError(t, err)
+	require.False(t, exists)
+}
+
+func TestDownloadURLResumeChanged(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 100)
+	digest, err := Digest256(bytes.NewReader(data))
+	require.NoError(t, err)
+	var ranges []string
+	server := testServerForContent(t, data, `"v2"`, &ranges)
+	defer server.Close()
+
+	destinationPath := TempPath("", "TestDownloadURLResumeChanged.")
+	defer RemoveFileAtPath(destinationPath)
+	savePath := destinationPath + ".download"
+
+	// Partial download from a different version (If-Range doesn't match)
+	err = ioutil.WriteFile(savePath, []byte("abcdef"), 0600)
+	require.NoError(t, err)
+	b, err := json.Marshal(DownloadInfo{URL: server.URL, ETag: `"v1"`})
+	require.NoError(t, err)
+	err = ioutil.WriteFile(DownloadInfoPath(savePath), b, 0600)
+	require.NoError(t, err)
+
+	err = DownloadURL(server.URL, destinationPath, DownloadURLOptions{Digest: digest})
+	require.NoError(t, err)
+	require.Equal(t, []string{"bytes=6-"}, ranges)
+	out, err := ioutil.ReadFile(destinationPath)
+	require.NoError(t, err)
+	require.Equal(t, data, out)
+}
+
+func TestDownloadURLResumeNotSatisfiable(t *testing.T) {
+	data := []byte("0123456789")
+	digest, err := Digest256(bytes.NewReader(data))
+	require.NoError(t, err)
+	var ranges []string
+	server := testServerForContent(t, data, `"v1"`, &ranges)
+	defer server.Close()
+
+	destinationPath := TempPath("", "TestDownloadURLResumeNotSatisfiable.")
+	defer RemoveFileAtPath(destinationPath)
+	savePath := destinationPath + ".download"
+
+	// Partial download larger than the file
+	err = ioutil.WriteFile(savePath, bytes.Repeat(data, 2), 0600)
+	require.NoError(t, err)
+	b, err := json.Marshal(DownloadInfo{URL: server.URL, ETag: `"v1"`})
+	require.NoError(t, err)
+	err = ioutil.WriteFile(DownloadInfoPath(savePath), b, 0600)
+	require.NoError(t, err)
+
+	err = DownloadURL(server.URL, destinationPath, DownloadURLOptions{Digest: digest})
+	require.NoError(t, err)
+	require.Equal(t, []string{"bytes=20-", ""}, ranges)
+	out, err := ioutil.ReadFile(destinationPath)
+	require.NoError(t, err)
+	require.Equal(t, data, out)
+}
+
+func TestDownloadURLProgress(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 100)
+	digest, err := Digest256(bytes.NewReader(data))
+	require.NoError(t, err)
+	var ranges []string
+	server := testServerForContent(t, data, `"v1"`, &ranges)
+	defer server.Close()
+
+	destinationPath := TempPath("", "TestDownloadURLProgress.")
+	defer RemoveFileAtPath(destinationPath)
+
+	var progress []Progress
+	progressFn := func(p Progress) { progress = append(progress, p) }
+	err = DownloadURL(server.URL, destinationPath, DownloadURLOptions{Digest: digest, Progress: progressFn})
+	require.NoError(t, err)
+	require.True(t, len(progress) > 0)
+	last := progress[len(progress)-1]
+	require.Equal(t, int64(1000), last.Done)
+	require.Equal(t, int64(1000), last.Total)
+
+	// Resumed download includes the partial bytes
+	RemoveFileAtPath(destinationPath)
+	savePath := destinationPath + ".download"
+	err = ioutil.WriteFile(savePath, data[:300], 0600)
+	require.NoError(t, err)
+	b, err := json.Marshal(DownloadInfo{URL: server.URL, ETag: `"v1"`})
+	require.NoError(t, err)
+	err = ioutil.WriteFile(DownloadInfoPath(savePath), b, 0600)
+	require.NoError(t, err)
+
+	progress = nil
+	err = DownloadURL(server.URL, destinationPath, DownloadURLOptions{Digest: digest, Progress: progressFn})
+	require.NoError(t, err)
+	require.True(t, len(progress) > 0)
+	require.True(t, progress[0].Done > 300)
+	last = progress[len(progress)-1]
+	require.Equal(t, int64(1000), last.Done)
+	require.Equal(t, int64(1000), last.Total)
+}
+
+func TestDownloadURLSize(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 100)
+	digest, err := Digest256(bytes.NewReader(data))
+	require.NoError(t, err)
+	var ranges []string
+	server := testServerForContent(t, data, `"v1"`, &ranges)
+	defer server.Close()
+
+	destinationPath := TempPath("", "TestDownloadURLSize.")
+	defer RemoveFileAtPath(destinationPath)
+
+	err = DownloadURL(server.URL, destinationPath, DownloadURLOptions{Digest: digest, Size: 999})
+	require.EqualError(t, err, "Content length (1000) doesn't match expected size (999)")
+	exists, err := FileExists(destinationPath + ".download")
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	err = DownloadURL(server.URL, destinationPath, DownloadURLOptions{Digest: digest, Size: 1000})
+	require.NoError(t, err)
+}
+
+func TestDownloadURLSizeExceeded(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 100)
+	// Streamed response (no Content-Length)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 10; i++ {
+			_, _ = w.Write(data[i*100 : (i+1)*100])
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer server.Close()
+
+	destinationPath := TempPath("", "TestDownloadURLSizeExceeded.")
+	defer RemoveFileAtPath(destinationPath)
+
+	err := DownloadURL(server.URL, destinationPath, DownloadURLOptions{SkipDigest: true, Size: 500})
+	require.EqualError(t, err, "Download exceeded expected size (500)")
+	exists, err := FileExists(destinationPath + ".download")
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	err = DownloadURL(server.URL, destinationPath, DownloadURLOptions{SkipDigest: true, Size: 1000})
+	require.NoError(t, err)
+}
+
+func TestDownloadURLLocalSize(t *testing.T) {
+	destinationPath := TempPath("", "TestDownloadURLLocalSize.")
+	defer RemoveFileAtPath(destinationPath)
+	path, err := filepath.Abs(filepath.Join("..", "test", "test.zip"))
+	require.NoError(t, err)
+	urs := URLStringForPath(path)
+	fi, err := os.Stat(path)
+	require.NoError(t, err)
+
+	err = DownloadURL(urs, destinationPath, DownloadURLOptions{SkipDigest: true, Size: 1})
+	require.EqualError(t, err, fmt.Sprintf("File size (%d) doesn't match expected size (1)", fi.Size()))
+	err = DownloadURL(urs, destinationPath, DownloadURLOptions{SkipDigest: true, Size: fi.Size()})
+	require.NoError(t, err)
+}
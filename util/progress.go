@@ -0,0 +1,69 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package util
+
+import (
+	"time"
+)
+
+// Progress describes download progress.
+type Progress struct {
+	// Done is number of bytes downloaded (including any resumed bytes).
+	Done int64 `json:"done"`
+	// Total is number of bytes expected, or 0 if unknown.
+	Total int64 `json:"total"`
+	// Rate is bytes per second.
+	Rate float64 `json:"rate"`
+}
+
+// ProgressFn is called with download progress.
+type ProgressFn func(p Progress)
+
+// progressInterval is the minimum time between progress reports.
+var progressInterval = 100 * time.Millisecond
+
+// progressWriter reports (throttled) progress for bytes written to it.
+type progressWriter struct {
+	fn    ProgressFn
+	done  int64
+	total int64
+
+	start     time.Time
+	startDone int64
+	last      time.Time
+}
+
+func newProgressWriter(fn ProgressFn, done int64, total int64) *progressWriter {
+	now := time.Now()
+	return &progressWriter{
+		fn:        fn,
+		done:      done,
+		total:     total,
+		start:     now,
+		startDone: done,
+	}
+}
+
+func (w *progressWriter) Write(b []byte) (int, error) {
+	w.done += int64(len(b))
+	now := time.Now()
+	if now.Sub(w.last) >= progressInterval {
+		w.report(now)
+	}
+	return len(b), nil
+}
+
+// finish reports final progress.
+func (w *progressWriter) finish() {
+	w.report(time.Now())
+}
+
+func (w *progressWriter) report(now time.Time) {
+	w.last = now
+	rate := float64(0)
+	if elapsed := now.Sub(w.start).Seconds(); elapsed > 0 {
+		rate = float64(w.done-w.startDone) / elapsed
+	}
+	w.fn(Progress{Done: w.done, Total: w.total, Rate: rate})
+}
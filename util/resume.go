@@ -0,0 +1,57 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package util
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// savePartialDownload saves the validators for a download from the response,
+// so we can resume it later.
+func savePartialDownload(urlString string, savePath string, resp *http.Response) error {
+	info := newDownloadInfo(urlString, resp)
+	if info.validator() == "" {
+		// Can't resume without a validator
+		removePartialDownload(savePath)
+		return nil
+	}
+	return info.Save(savePath)
+}
+
+// resumePartialDownload returns the offset and If-Range validator for a
+// partial download at savePath, or 0 if there isn't one we can resume.
+func resumePartialDownload(urlString string, savePath string) (int64, string) {
+	fileInfo, err := os.Stat(savePath)
+	if err != nil || fileInfo.Size() == 0 {
+		return 0, ""
+	}
+	info, err := LoadDownloadInfo(savePath)
+	if err != nil {
+		logger.Warningf("Invalid partial download info: %s", err)
+		return 0, ""
+	}
+	if info == nil || info.URL != urlString || info.validator() == "" {
+		return 0, ""
+	}
+	return fileInfo.Size(), info.validator()
+}
+
+// removePartialDownload removes a partial download and its info.
+func removePartialDownload(savePath string) {
+	RemoveFileAtPath(savePath)
+	RemoveFileAtPath(DownloadInfoPath(savePath))
+}
+
+// contentRangeStart returns the start of the Content-Range header, for
+// example, "bytes 100-199/200" => 100.
+func contentRangeStart(resp *http.Response) (int64, error) {
+	contentRange := resp.Header.Get("Content-Range")
+	var start, end int64
+	if _, err := fmt.Sscanf(contentRange, "bytes %d-%d", &start, &end); err != nil {
+		return 0, fmt.Errorf("Invalid Content-Range: %q", contentRange)
+	}
+	return start, nil
+}
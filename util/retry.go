@@ -0,0 +1,116 @@
+package util
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RetryPolicy is how many times, and how long to wait, before retrying a
+// request that failed with a (possibly) temporary error, see Retryable.
+type RetryPolicy struct {
+	// Attempts is the maximum number of attempts (0 or 1 means no retries).
+	Attempts int
+	// MinBackoff is the delay before the first retry, doubled for each retry
+	// after that, with jitter.
+	MinBackoff time.Duration
+	// MaxBackoff is the maximum delay.
+	// If the server asks us to wait longer (Retry-After), we give up.
+	MaxBackoff time.Duration
+}
+
+// DefaultRetryPolicy retries 2 times, after about 1s and 2s.
+var DefaultRetryPolicy = RetryPolicy{Attempts: 3, MinBackoff: time.Second, MaxBackoff: 30 * time.Second}
+
+// HTTPStatusError is an error for an unexpected HTTP response status.
+type HTTPStatusError struct {
+	StatusCode int
+	Status     string
+	// RetryAfter is from the Retry-After header (if set).
+	RetryAfter time.Duration
+}
+
+// NewHTTPStatusError returns an error for the response status.
+func NewHTTPStatusError(resp *http.Response) HTTPStatusError {
+	return HTTPStatusError{
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		RetryAfter: retryAfter(resp.Header.Get("Retry-After"), time.Now()),
+	}
+}
+
+func (e HTTPStatusError) Error() string {
+	return e.Status
+}
+
+// retryAfter parses a Retry-After header (seconds or an HTTP date).
+func retryAfter(s string, now time.Time) time.Duration {
+	if s == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(s); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(s); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+// Retryable returns true if the error is (possibly) temporary, a 5xx or 429
+// (Too Many Requests) response, or the connection was reset.
+func Retryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if serr, ok := errors.Cause(err).(HTTPStatusError); ok {
+		return serr.StatusCode >= 500 || serr.StatusCode == http.StatusTooManyRequests
+	}
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// backoff returns the delay before a retry (after attempt failed).
+func (p RetryPolicy) backoff(attempt int, err error, jitter float64) (time.Duration, bool) {
+	if serr, ok := errors.Cause(err).(HTTPStatusError); ok && serr.RetryAfter > 0 {
+		if serr.RetryAfter > p.MaxBackoff {
+			return 0, false
+		}
+		return serr.RetryAfter, true
+	}
+	delay := p.MinBackoff
+	for i := 1; i < attempt && delay < p.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+	// Jitter between half and the full delay
+	return delay/2 + time.Duration(float64(delay/2)*jitter), true
+}
+
+// Do calls fn until it succeeds, returns an error that isn't Retryable, or we
+// run out of attempts.
+func (p RetryPolicy) Do(ctx context.Context, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || ctx.Err() != nil || attempt >= p.Attempts || !Retryable(err) {
+			return err
+		}
+		delay, ok := p.backoff(attempt, err, rand.Float64())
+		if !ok {
+			return err
+		}
+		logger.Infof("Retrying in %s (attempt %d of %d): %v", delay, attempt+1, p.Attempts, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
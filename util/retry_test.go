@@ -0,0 +1,128 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryable(t *testing.T) {
+	require.False(t, Retryable(nil))
+	require.True(t, Retryable(HTTPStatusError{StatusCode: 503}))
+	require.True(t, Retryable(HTTPStatusError{StatusCode: 429}))
+	require.False(t, Retryable(HTTPStatusError{StatusCode: 404}))
+	require.True(t, Retryable(errors.Wrapf(HTTPStatusError{StatusCode: 500}, "Request failed")))
+	require.True(t, Retryable(fmt.Errorf("read: %w", syscall.ECONNRESET)))
+	require.False(t, Retryable(context.Canceled))
+	require.False(t, Retryable(fmt.Errorf("Invalid digest")))
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	require.Equal(t, 2*time.Second, retryAfter("2", now))
+	require.Equal(t, time.Minute, retryAfter("Wed, 01 Jan 2020 00:01:00 GMT", now))
+	require.Equal(t, time.Duration(0), retryAfter("Tue, 31 Dec 2019 00:00:00 GMT", now))
+	require.Equal(t, time.Duration(0), retryAfter("invalid", now))
+}
+
+func TestBackoff(t *testing.T) {
+	p := RetryPolicy{Attempts: 5, MinBackoff: time.Second, MaxBackoff: 5 * time.Second}
+	err := fmt.Errorf("read: %w", syscall.ECONNRESET)
+	delay, ok := p.backoff(1, err, 1)
+	require.True(t, ok)
+	require.Equal(t, time.Second, delay)
+	delay, _ = p.backoff(1, err, 0)
+	require.Equal(t, 500*time.Millisecond, delay)
+	delay, _ = p.backoff(2, err, 1)
+	require.Equal(t, 2*time.Second, delay)
+	delay, _ = p.backoff(4, err, 1)
+	require.Equal(t, 5*time.Second, delay)
+
+	delay, ok = p.backoff(1, HTTPStatusError{StatusCode: 429, RetryAfter: 3 * time.Second}, 0)
+	require.True(t, ok)
+	require.Equal(t, 3*time.Second, delay)
+	_, ok = p.backoff(1, HTTPStatusError{StatusCode: 429, RetryAfter: time.Minute}, 0)
+	require.False(t, ok)
+}
+
+func TestDownloadURLRetry(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch requests {
+		case 1:
+			http.Error(w, "Unavailable", http.StatusServiceUnavailable)
+		case 2:
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+		default:
+			fmt.Fprintln(w, "ok")
+		}
+	}))
+	defer server.Close()
+	destinationPath := TempPath("", "TestDownloadURLRetry.")
+	defer RemoveFileAtPath(destinationPath)
+	defer RemoveFileAtPath(DownloadInfoPath(destinationPath))
+
+	retry := RetryPolicy{Attempts: 3, MinBackoff: time.Millisecond, MaxBackoff: 2 * time.Second}
+	err := DownloadURL(server.URL, destinationPath, DownloadURLOptions{SkipDigest: true, Retry: retry})
+	require.NoError(t, err)
+	require.Equal(t, 3, requests)
+
+	// Not found isn't retried
+	requests = 0
+	notFound := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.Error(w, "Not Found", http.StatusNotFound)
+	}))
+	defer notFound.Close()
+	err = DownloadURL(notFound.URL, destinationPath, DownloadURLOptions{SkipDigest: true, Retry: retry})
+	require.EqualError(t, err, "404 Not Found")
+	require.Equal(t, 1, requests)
+}
+
+func TestDownloadURLRetryCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	requested := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Partial response (connection closed early), which is retried
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Content-Length", "1024")
+		fmt.Fprint(w, "partial")
+		requested <- struct{}{}
+	}))
+	defer server.Close()
+	destinationPath := TempPath("", "TestDownloadURLRetryCancel.")
+	defer RemoveFileAtPath(destinationPath)
+	savePath := destinationPath + ".download"
+
+	go func() {
+		<-requested
+		// Wait until we're waiting to retry (the partial download is kept)
+		for i := 0; i < 100; i++ {
+			if exists, _ := FileExists(savePath); exists {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		cancel()
+	}()
+
+	retry := RetryPolicy{Attempts: 3, MinBackoff: time.Hour, MaxBackoff: time.Hour}
+	err := DownloadURLContext(ctx, server.URL, destinationPath, DownloadURLOptions{SkipDigest: true, Retry: retry})
+	require.Equal(t, context.Canceled, err)
+	exists, err := FileExists(savePath)
+	require.NoError(t, err)
+	require.False(t, exists)
+	exists, err = FileExists(DownloadInfoPath(savePath))
+	require.NoError(t, err)
+	require.False(t, exists)
+}
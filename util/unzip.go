@@ -9,12 +9,12 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
-	"path/filepath"
 )
 
 // UnzipOver safely unzips a file and copies it contents to a destination path.
 // If destination path exists, it will be removed first.
-// The filename must have a ".zip" extension.
+// This is the same as Extract, which supports other archive formats, and
+// detects the format from the filename or contents.
 // You can specify a check function, which will run before moving the unzipped
 // directory into place.
 // If you specify a tmpDir and destination path exists, it will be moved there
@@ -25,21 +25,7 @@ import (
 //   UnzipOver("/tmp/Keybase-1.2.3.zip", "Keybase.app", "/Applications/Keybase.app", check, "")
 //
 func UnzipOver(sourcePath string, path string, destinationPath string, check func(sourcePath, destinationPath string) error, tmpDir string) error {
-	unzipPath := fmt.Sprintf("%s.unzipped", sourcePath)
-	defer RemoveFileAtPath(unzipPath)
-	err := unzipOver(sourcePath, unzipPath)
-	if err != nil {
-		return err
-	}
-
-	contentPath := filepath.Join(unzipPath, path)
-
-	err = check(contentPath, destinationPath)
-	if err != nil {
-		return err
-	}
-
-	return MoveFile(contentPath, destinationPath, tmpDir)
+	return Extract(sourcePath, path, destinationPath, check, tmpDir)
 }
 
 // UnzipPath unzips and returns path to unzipped directory
@@ -71,11 +57,21 @@ func unzipOver(sourcePath string, destinationPath string) error {
 
 // Unzip unpacks a zip file to a destination.
 // This unpacks files using the current user and time (it doesn't preserve).
+// Entries with absolute paths or paths outside of the destination, symlinks
+// that point outside of the destination, or archives exceeding
+// DefaultArchiveLimits, fail with an ArchiveError.
 // This code was modified from https://stackoverflow.com/questions/20357223/easy-way-to-unzip-file-with-golang/20357902
 func Unzip(sourcePath, destinationPath string) error {
+	_, err := UnzipWithOptions(sourcePath, destinationPath, UnzipOptions{Limits: DefaultArchiveLimits})
+	return err
+}
+
+// UnzipWithOptions unpacks a zip file to a destination (see Unzip) with
+// options, and returns a manifest of the extracted files.
+func UnzipWithOptions(sourcePath, destinationPath string, options UnzipOptions) ([]ArchiveEntry, error) {
 	r, err := zip.OpenReader(sourcePath)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer func() {
 		if closeErr := r.Close(); closeErr != nil {
@@ -83,13 +79,33 @@ func Unzip(sourcePath, destinationPath string) error {
 		}
 	}()
 
-	err = os.MkdirAll(destinationPath, 0755)
+	limits := options.Limits
+	if err := limits.checkEntries(len(r.File)); err != nil {
+		return nil, err
+	}
+	total := uint64(0)
+	for _, f := range r.File {
+		total += f.UncompressedSize64
+		if limits.MaxSize > 0 && total > uint64(limits.MaxSize) {
+			return nil, ArchiveError{Reason: fmt.Sprintf("total size exceeds %d", limits.MaxSize)}
+		}
+		if err := limits.checkRatio(f.Name, int64(f.CompressedSize64), int64(f.UncompressedSize64)); err != nil {
+			return nil, err
+		}
+	}
+
+	x, err := newArchiveExtractor(destinationPath, options)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Closure to address file descriptors issue with all the deferred .Close() methods
 	extractAndWriteFile := func(f *zip.File) error {
+		fileInfo := f.FileInfo()
+		if fileInfo.IsDir() {
+			return x.dir(f.Name, fileInfo.Mode(), fileInfo.ModTime())
+		}
+
 		rc, err := f.Open()
 		if err != nil {
 			return err
@@ -100,49 +116,22 @@ func Unzip(sourcePath, destinationPath string) error {
 			}
 		}()
 
-		filePath := filepath.Join(destinationPath, f.Name)
-		fileInfo := f.FileInfo()
-
-		if fileInfo.IsDir() {
-			err := os.MkdirAll(filePath, fileInfo.Mode())
-			if err != nil {
-				return err
-			}
-		} else {
-			err := os.MkdirAll(filepath.Dir(filePath), 0755)
-			if err != nil {
-				return err
-			}
-
-			if fileInfo.Mode()&os.ModeSymlink != 0 {
-				linkName, readErr := ioutil.ReadAll(rc)
-				if readErr != nil {
-					return readErr
-				}
-				return os.Symlink(string(linkName), filePath)
-			}
-
-			fileCopy, err := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, fileInfo.Mode())
-			if err != nil {
-				return err
-			}
-			defer Close(fileCopy)
-
-			_, err = io.Copy(fileCopy, rc)
-			if err != nil {
-				return err
+		if fileInfo.Mode()&os.ModeSymlink != 0 {
+			linkName, readErr := ioutil.ReadAll(io.LimitReader(rc, 4096))
+			if readErr != nil {
+				return readErr
 			}
+			return x.symlink(f.Name, string(linkName))
 		}
-
-		return nil
+		return x.file(f.Name, fileInfo.Mode(), fileInfo.ModTime(), rc)
 	}
 
 	for _, f := range r.File {
 		err := extractAndWriteFile(f)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
-	return nil
+	return x.finish()
 }
@@ -67,3 +67,41 @@ func TestUnzipFileModTime(t *testing.T) {
 	t.Logf("Diff (file): %s", diffFile)
 	assert.True(t, diffFile >= 0, "now=%s, filetime=%s", now, fileMod)
 }
+
+func TestUnzipWithOptions(t *testing.T) {
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	path := testZip(t, []testZipEntry{
+		{name: "bin/", mode: os.ModeDir | 0777, modTime: modTime},
+		{name: "bin/app", mode: os.ModeSetuid | 0755, data: []byte("app"), modTime: modTime},
+		{name: "readme", mode: 0666, data: []byte("readme"), modTime: modTime},
+	})
+	defer RemoveFileAtPath(path)
+	destinationPath := TempPath("", "TestUnzipWithOptions.")
+	defer RemoveFileAtPath(destinationPath)
+
+	entries, err := UnzipWithOptions(path, destinationPath, UnzipOptions{
+		PreserveModTime: true,
+		PreserveExec:    true,
+		StripModes:      true,
+	})
+	require.NoError(t, err)
+	expected := []ArchiveEntry{
+		{Path: "bin", Mode: os.ModeDir | 0755},
+		{Path: "bin/app", Mode: 0755, Size: 3, Digest: "a172cedcae47474b615c54d510a5d84a8dea3032e958587430b413538be3f333"},
+		{Path: "readme", Mode: 0644, Size: 6, Digest: "711a6108ba2ce6ca93dd47d6817f2361db10d8ab6eec89460b2dfc2c325efabe"},
+	}
+	require.Equal(t, expected, entries)
+
+	for _, name := range []string{"bin", "bin/app", "readme"} {
+		fileInfo, err := os.Stat(filepath.Join(destinationPath, name))
+		require.NoError(t, err)
+		require.True(t, modTime.Equal(fileInfo.ModTime()), "%s: %s", name, fileInfo.ModTime())
+	}
+
+	// Without options, setuid bit isn't stripped
+	destinationPath2 := TempPath("", "TestUnzipWithOptions.")
+	defer RemoveFileAtPath(destinationPath2)
+	entries, err = UnzipWithOptions(path, destinationPath2, UnzipOptions{})
+	require.NoError(t, err)
+	require.Equal(t, os.ModeSetuid, entries[1].Mode&os.ModeSetuid)
+}
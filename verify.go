@@ -0,0 +1,117 @@
+package updater
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/keys-pub/updater/util"
+	"github.com/pkg/errors"
+)
+
+// Verifier verifies a downloaded asset, for example, checking the asset
+// Signature against trusted keys.
+type Verifier interface {
+	// Verify returns an error if the asset at path isn't valid.
+	Verify(asset *Asset, path string) error
+}
+
+const ed25519KeyIDLength = 8
+
+// Ed25519Verifier verifies minisign-style Ed25519 signatures.
+//
+// The signature is base64 encoded key ID (8 bytes) followed by an Ed25519
+// signature (64 bytes) of the SHA512 digest of the file.
+// The key ID is the first 8 bytes of the SHA256 digest of the public key.
+type Ed25519Verifier struct {
+	publicKeys []ed25519.PublicKey
+}
+
+// NewEd25519Verifier returns an Ed25519Verifier for trusted public keys.
+func NewEd25519Verifier(publicKeys ...ed25519.PublicKey) *Ed25519Verifier {
+	return &Ed25519Verifier{publicKeys: publicKeys}
+}
+
+// ParseEd25519PublicKey parses a base64 encoded Ed25519 public key.
+func ParseEd25519PublicKey(s string) (ed25519.PublicKey, error) {
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, errors.Wrapf(err, "Invalid public key")
+	}
+	if len(b) != ed25519.PublicKeySize {
+		return nil, errors.Errorf("Invalid public key length")
+	}
+	return ed25519.PublicKey(b), nil
+}
+
+// Ed25519KeyID returns the key ID for a public key.
+func Ed25519KeyID(publicKey ed25519.PublicKey) []byte {
+	h := sha256.Sum256(publicKey)
+	return h[:ed25519KeyIDLength]
+}
+
+// Ed25519Sign returns a signature for data (for use with Ed25519Verifier).
+func Ed25519Sign(privateKey ed25519.PrivateKey, r io.Reader) (string, error) {
+	digest, err := sha512Digest(r)
+	if err != nil {
+		return "", err
+	}
+	publicKey := privateKey.Public().(ed25519.PublicKey)
+	sig := append(Ed25519KeyID(publicKey), ed25519.Sign(privateKey, digest)...)
+	return base64.StdEncoding.EncodeToString(sig), nil
+}
+
+// Verify asset at path.
+func (v *Ed25519Verifier) Verify(asset *Asset, path string) error {
+	if asset == nil || asset.Signature == "" {
+		return errors.Errorf("Missing signature")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer util.Close(f)
+	return v.VerifySignature(asset.Signature, f)
+}
+
+// VerifySignature verifies a signature (from Ed25519Sign) for data.
+func (v *Ed25519Verifier) VerifySignature(signature string, r io.Reader) error {
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(signature))
+	if err != nil {
+		return errors.Wrapf(err, "Invalid signature")
+	}
+	if len(b) != ed25519KeyIDLength+ed25519.SignatureSize {
+		return errors.Errorf("Invalid signature length")
+	}
+	keyID, sig := b[:ed25519KeyIDLength], b[ed25519KeyIDLength:]
+
+	digest, err := sha512Digest(r)
+	if err != nil {
+		return err
+	}
+
+	for _, publicKey := range v.publicKeys {
+		if !bytes.Equal(Ed25519KeyID(publicKey), keyID) {
+			continue
+		}
+		if !ed25519.Verify(publicKey, digest, sig) {
+			return errors.Errorf("Invalid signature")
+		}
+		logger.Infof("Verified signature (%x)", keyID)
+		return nil
+	}
+	return errors.Errorf("Unknown signature key (%x)", keyID)
+}
+
+func sha512Digest(r io.Reader) ([]byte, error) {
+	hasher := sha512.New()
+	if _, err := io.Copy(hasher, r); err != nil {
+		return nil, err
+	}
+	return hasher.Sum(nil), nil
+}
@@ -0,0 +1,122 @@
+package updater
+
+import (
+	"strings"
+
+	"github.com/blang/semver"
+	"github.com/pkg/errors"
+)
+
+// VersionPolicy decides which versions we update to.
+type VersionPolicy struct {
+	// AllowDowngrade allows updating to an older version, after switching to
+	// a channel that doesn't include the current version.
+	AllowDowngrade bool `json:"allowDowngrade,omitempty"`
+	// CompareBuild compares build metadata, so a version with different build
+	// metadata (for example, 1.2.3+abc to 1.2.3+def) is an update, and Skip
+	// versions match the build metadata. By default, build metadata is
+	// ignored (as semver does).
+	CompareBuild bool `json:"compareBuild,omitempty"`
+	// Min is the minimum version to update to.
+	Min string `json:"min,omitempty"`
+	// Max is the maximum version to update to.
+	Max string `json:"max,omitempty"`
+	// Skip are versions not to update to.
+	Skip []string `json:"skip,omitempty"`
+}
+
+// DefaultVersionPolicy is the policy if UpdateOptions.Policy isn't set.
+var DefaultVersionPolicy = VersionPolicy{}
+
+// VersionPolicy returns the version policy for options.
+func (o UpdateOptions) VersionPolicy() VersionPolicy {
+	if o.Policy != nil {
+		return *o.Policy
+	}
+	return DefaultVersionPolicy
+}
+
+func (p VersionPolicy) equal(a semver.Version, b semver.Version) bool {
+	if !a.EQ(b) {
+		return false
+	}
+	return !p.CompareBuild || strings.Join(a.Build, ".") == strings.Join(b.Build, ".")
+}
+
+// Allows returns true if the policy allows updating to version (from Min,
+// Max and Skip).
+func (p VersionPolicy) Allows(v semver.Version) (bool, error) {
+	if p.Min != "" {
+		min, err := semver.Make(p.Min)
+		if err != nil {
+			return false, errors.Wrapf(err, "Invalid minimum version %q", p.Min)
+		}
+		if v.LT(min) {
+			return false, nil
+		}
+	}
+	if p.Max != "" {
+		max, err := semver.Make(p.Max)
+		if err != nil {
+			return false, errors.Wrapf(err, "Invalid maximum version %q", p.Max)
+		}
+		if v.GT(max) {
+			return false, nil
+		}
+	}
+	for _, s := range p.Skip {
+		skip, err := semver.Make(s)
+		if err != nil {
+			return false, errors.Wrapf(err, "Invalid skip version %q", s)
+		}
+		if p.equal(v, skip) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Allows returns true if version is in the release channel, and allowed by
+// the version policy.
+// Update sources use this to pick the best update (see NeedUpdate).
+func (o UpdateOptions) Allows(v semver.Version) (bool, error) {
+	if !o.ReleaseChannel().Includes(v) {
+		return false, nil
+	}
+	return o.VersionPolicy().Allows(v)
+}
+
+// needUpdate returns true if we should update from the current to next
+// version, in channel.
+// If next is older, we only update if AllowDowngrade is set and the current
+// version isn't in the channel (we switched channels), for example, from
+// 1.2.0-beta.1 to 1.1.0 after switching from beta to stable.
+func (p VersionPolicy) needUpdate(channel Channel, current semver.Version, next semver.Version) bool {
+	switch {
+	case current.LT(next):
+		return true
+	case current.EQ(next):
+		return !p.equal(current, next)
+	default:
+		return p.AllowDowngrade && !channel.Includes(current)
+	}
+}
+
+// NeedUpdate returns true if we should update from the current version
+// (options.Version) to version.
+// The version must be in the release channel and allowed by the version
+// policy (see UpdateOptions.Allows).
+func NeedUpdate(options UpdateOptions, version string) (bool, error) {
+	current, err := semver.Make(options.Version)
+	if err != nil {
+		return false, errors.Wrapf(err, "Invalid current version %q", options.Version)
+	}
+	next, err := semver.Make(version)
+	if err != nil {
+		return false, errors.Wrapf(err, "Invalid update version %q", version)
+	}
+	if ok, err := options.Allows(next); err != nil || !ok {
+		return false, err
+	}
+	return options.VersionPolicy().needUpdate(options.ReleaseChannel(), current, next), nil
+}
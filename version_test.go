@@ -0,0 +1,57 @@
+package updater
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNeedUpdate(t *testing.T) {
+	needUpdate := func(options UpdateOptions, current string, next string) bool {
+		options.Version = current
+		ok, err := NeedUpdate(options, next)
+		require.NoError(t, err)
+		return ok
+	}
+	require.True(t, needUpdate(UpdateOptions{}, "1.1.0", "1.2.0"))
+	require.False(t, needUpdate(UpdateOptions{}, "1.2.0", "1.2.0"))
+	require.False(t, needUpdate(UpdateOptions{}, "1.2.0-beta.1", "1.1.0"))
+
+	_, err := NeedUpdate(UpdateOptions{Version: "garbage"}, "1.2.0")
+	require.EqualError(t, err, `Invalid current version "garbage": No Major.Minor.Patch elements found`)
+	_, err = NeedUpdate(UpdateOptions{Version: "1.2.0"}, "v1.2.1")
+	require.EqualError(t, err, `Invalid update version "v1.2.1": Invalid character(s) found in major number "v1"`)
+
+	// Switched from beta to stable
+	options := UpdateOptions{Channel: Stable, Policy: &VersionPolicy{AllowDowngrade: true}}
+	require.True(t, needUpdate(options, "1.2.0-beta.1", "1.1.0"))
+	// Still in the channel
+	require.False(t, needUpdate(options, "1.2.0", "1.1.0"))
+
+	// Channel
+	require.False(t, needUpdate(UpdateOptions{}, "1.1.0", "1.2.0-beta.1"))
+	require.True(t, needUpdate(UpdateOptions{Channel: Beta}, "1.1.0", "1.2.0-beta.1"))
+	require.False(t, needUpdate(UpdateOptions{Channel: Beta}, "1.1.0", "1.2.0-alpha.1"))
+
+	// Build metadata
+	require.False(t, needUpdate(UpdateOptions{}, "1.2.0+abc", "1.2.0+def"))
+	// Policy zero value ignores build metadata (like the default)
+	options = UpdateOptions{Policy: &VersionPolicy{Max: "1.99.99"}}
+	require.False(t, needUpdate(options, "1.2.0+abc", "1.2.0+def"))
+	options = UpdateOptions{Policy: &VersionPolicy{CompareBuild: true}}
+	require.True(t, needUpdate(options, "1.2.0+abc", "1.2.0+def"))
+	require.False(t, needUpdate(options, "1.2.0+abc", "1.2.0+abc"))
+
+	// Min, max, skip
+	options = UpdateOptions{Policy: &VersionPolicy{Min: "1.1.0", Max: "1.9.9", Skip: []string{"1.2.1"}}}
+	require.False(t, needUpdate(options, "1.0.0", "1.0.1"))
+	require.True(t, needUpdate(options, "1.0.0", "1.1.0"))
+	require.False(t, needUpdate(options, "1.2.0", "1.2.1"))
+	require.False(t, needUpdate(options, "1.2.0", "1.2.1+abc"))
+	require.True(t, needUpdate(options, "1.2.0", "1.2.2"))
+	require.False(t, needUpdate(options, "1.2.0", "2.0.0"))
+
+	options = UpdateOptions{Version: "1.2.0", Policy: &VersionPolicy{Skip: []string{"invalid"}}}
+	_, err = NeedUpdate(options, "1.2.1")
+	require.EqualError(t, err, `Invalid skip version "invalid": No Major.Minor.Patch elements found`)
+}